@@ -0,0 +1,96 @@
+// Command jade provides tools for Jade templates.
+//
+// Usage:
+//
+//	jade extract [-format pot|json] [-o file] [-ext .jade] dir...
+//
+// The extract command collects the translatable messages of all templates in the given directories
+// and writes them as a gettext POT template or a JSON list.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-floki/jade"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: jade extract [-format pot|json] [-o file] [-ext .jade] dir...")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "extract":
+		if err := extract(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "jade:", err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+func extract(args []string) error {
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	format := flags.String("format", "pot", "output format, pot or json")
+	output := flags.String("o", "", "output file, standard output if empty")
+	ext := flags.String("ext", jade.DefaultDirOptions.Ext, "extension of template files")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		usage()
+	}
+
+	write := jade.WritePOT
+	switch *format {
+	case "pot":
+	case "json":
+		write = jade.WriteJSON
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	var messages []*jade.Message
+	seen := make(map[string]*jade.Message)
+
+	for _, dir := range flags.Args() {
+		found, err := jade.ExtractDir(dir, jade.DirOptions{Ext: *ext, Recursive: true}, jade.DefaultOptions)
+		if err != nil {
+			return err
+		}
+
+		// Merge messages used in several directories
+		for _, msg := range found {
+			if known, ok := seen[msg.Key]; ok {
+				known.Positions = append(known.Positions, msg.Positions...)
+				if len(msg.Plural) > 0 {
+					known.Plural = msg.Plural
+				}
+				continue
+			}
+
+			seen[msg.Key] = msg
+			messages = append(messages, msg)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if len(*output) > 0 {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return write(w, messages)
+}
@@ -0,0 +1,356 @@
+package jade
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Collection helpers accept slices, arrays and maps as their last argument. Maps are treated as the
+// list of their values ordered by key, so that the output does not depend on map iteration order.
+
+// Group is an item of the result of groupBy. Groups are ordered by the first appearance of their key.
+type Group struct {
+	Key   interface{}
+	Items []interface{}
+}
+
+// Returns the keys of a map in a deterministic order.
+func runtime_sortedKeys(vm reflect.Value) []reflect.Value {
+	keys := vm.MapKeys()
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		if r, err := runtime_compare("<", keys[i].Interface(), keys[j].Interface()); err == nil {
+			return r < 0
+		}
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	return keys
+}
+
+// Converts a collection into the list of its items.
+func runtime_items(name string, x interface{}) ([]interface{}, error) {
+	vx := reflect.ValueOf(x)
+	for vx.Kind() == reflect.Ptr && !vx.IsNil() {
+		vx = vx.Elem()
+	}
+
+	switch vx.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, vx.Len())
+		for i := range items {
+			items[i] = vx.Index(i).Interface()
+		}
+		return items, nil
+	case reflect.Map:
+		keys := runtime_sortedKeys(vx)
+		items := make([]interface{}, len(keys))
+		for i, key := range keys {
+			items[i] = vx.MapIndex(key).Interface()
+		}
+		return items, nil
+	case reflect.Ptr:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("%s: %T is not a collection", name, x)
+}
+
+// Looks up a dot separated path of fields, map keys or methods without arguments on item.
+func runtime_field(name string, item interface{}, key string) (interface{}, error) {
+	for _, part := range strings.Split(key, ".") {
+		vi := reflect.ValueOf(item)
+		if !vi.IsValid() {
+			return nil, nil
+		}
+
+		if method := vi.MethodByName(part); method.IsValid() && method.Type().NumIn() == 0 && method.Type().NumOut() == 1 {
+			item = method.Call(nil)[0].Interface()
+			continue
+		}
+
+		for vi.Kind() == reflect.Ptr || vi.Kind() == reflect.Interface {
+			if vi.IsNil() {
+				return nil, nil
+			}
+			vi = vi.Elem()
+		}
+
+		switch vi.Kind() {
+		case reflect.Struct:
+			field := vi.FieldByName(part)
+			if !field.IsValid() || !field.CanInterface() {
+				return nil, fmt.Errorf("%s: %s has no field %s", name, vi.Type(), part)
+			}
+			item = field.Interface()
+		case reflect.Map:
+			value := vi.MapIndex(reflect.ValueOf(part))
+			if !value.IsValid() {
+				item = nil
+			} else {
+				item = value.Interface()
+			}
+		default:
+			return nil, fmt.Errorf("%s: can not look up %s on %s", name, part, vi.Type())
+		}
+	}
+
+	return item, nil
+}
+
+func runtime_first(list interface{}) (interface{}, error) {
+	items, err := runtime_items("first", list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	return items[0], nil
+}
+
+func runtime_last(list interface{}) (interface{}, error) {
+	items, err := runtime_items("last", list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	return items[len(items)-1], nil
+}
+
+// Sorts the items of a collection by a field or map key. A leading `-` sorts in descending order:
+// `sortBy "-CreatedAt" Posts`. The sort is stable.
+func runtime_sortBy(key string, list interface{}) ([]interface{}, error) {
+	items, err := runtime_items("sortBy", list)
+	if err != nil {
+		return nil, err
+	}
+
+	descending := strings.HasPrefix(key, "-")
+	key = strings.TrimPrefix(key, "-")
+
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		if values[i], err = runtime_field("sortBy", item, key); err != nil {
+			return nil, err
+		}
+	}
+
+	indexes := make([]int, len(items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+
+		var r int
+		r, err = runtime_compare("<", values[indexes[i]], values[indexes[j]])
+		if descending {
+			return r > 0
+		}
+		return r < 0
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("sortBy: %s", err)
+	}
+
+	sorted := make([]interface{}, len(items))
+	for i, index := range indexes {
+		sorted[i] = items[index]
+	}
+
+	return sorted, nil
+}
+
+// Groups the items of a collection by a field or map key: `each $group in groupBy("Category", Products)`.
+func runtime_groupBy(key string, list interface{}) ([]Group, error) {
+	items, err := runtime_items("groupBy", list)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []Group
+
+outer:
+	for _, item := range items {
+		value, err := runtime_field("groupBy", item, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range groups {
+			if runtime_eql(groups[i].Key, value) {
+				groups[i].Items = append(groups[i].Items, item)
+				continue outer
+			}
+		}
+
+		groups = append(groups, Group{Key: value, Items: []interface{}{item}})
+	}
+
+	return groups, nil
+}
+
+// Reports whether a value is non empty, following the truth rules of templates: false, zero numbers,
+// nil and empty strings, slices and maps are false.
+func runtime_truthy(x interface{}) bool {
+	vx := reflect.ValueOf(x)
+	if !vx.IsValid() {
+		return false
+	}
+
+	switch vx.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String, reflect.Chan:
+		return vx.Len() > 0
+	case reflect.Struct:
+		return true
+	}
+
+	return !vx.IsZero()
+}
+
+// Keeps the items of a collection whose field is non empty, `filter "Published" Posts`, or equal to a
+// value, `filter "Category" "fruit" Products`. Items keep their order.
+func runtime_filter(key string, args ...interface{}) ([]interface{}, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("filter: expected a field, an optional value and a collection")
+	}
+
+	items, err := runtime_items("filter", args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		value, err := runtime_field("filter", item, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(args) == 2 && runtime_eql(value, args[0]) || len(args) == 1 && runtime_truthy(value) {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered, nil
+}
+
+// Splits a collection into lists of at most size items.
+func runtime_chunk(size int, list interface{}) ([][]interface{}, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("chunk: invalid size %d", size)
+	}
+
+	items, err := runtime_items("chunk", list)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks [][]interface{}
+	for len(items) > size {
+		chunks = append(chunks, items[:size])
+		items = items[size:]
+	}
+
+	if len(items) > 0 {
+		chunks = append(chunks, items)
+	}
+
+	return chunks, nil
+}
+
+func runtime_reverse(list interface{}) ([]interface{}, error) {
+	items, err := runtime_items("reverse", list)
+	if err != nil {
+		return nil, err
+	}
+
+	reversed := make([]interface{}, len(items))
+	for i, item := range items {
+		reversed[len(items)-1-i] = item
+	}
+
+	return reversed, nil
+}
+
+// Removes duplicate items, keeping the first occurrence.
+func runtime_uniq(list interface{}) ([]interface{}, error) {
+	items, err := runtime_items("uniq", list)
+	if err != nil {
+		return nil, err
+	}
+
+	var unique []interface{}
+
+outer:
+	for _, item := range items {
+		for _, seen := range unique {
+			if runtime_eql(seen, item) {
+				continue outer
+			}
+		}
+
+		unique = append(unique, item)
+	}
+
+	return unique, nil
+}
+
+// Adds up the items of a collection, or a field of each item: `sum "Price" Items`.
+func runtime_sum(args ...interface{}) (interface{}, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("sum: expected a collection and an optional field")
+	}
+
+	items, err := runtime_items("sum", args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	var total interface{} = 0
+	for _, item := range items {
+		if len(args) == 2 {
+			if item, err = runtime_field("sum", item, runtime_string(args[0])); err != nil {
+				return nil, err
+			}
+		}
+
+		if total, err = runtime_numeric("+", total, item, numericAdd); err != nil {
+			return nil, fmt.Errorf("sum: %s", err)
+		}
+	}
+
+	return total, nil
+}
+
+// Returns the sorted keys of a map.
+func runtime_keys(x interface{}) ([]interface{}, error) {
+	vx := reflect.Indirect(reflect.ValueOf(x))
+	if vx.Kind() != reflect.Map {
+		return nil, fmt.Errorf("keys: %T is not a map", x)
+	}
+
+	keys := runtime_sortedKeys(vx)
+	result := make([]interface{}, len(keys))
+	for i, key := range keys {
+		result[i] = key.Interface()
+	}
+
+	return result, nil
+}
+
+// Returns the values of a map ordered by their keys.
+func runtime_values(x interface{}) ([]interface{}, error) {
+	if reflect.Indirect(reflect.ValueOf(x)).Kind() != reflect.Map {
+		return nil, fmt.Errorf("values: %T is not a map", x)
+	}
+
+	return runtime_items("values", x)
+}
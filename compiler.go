@@ -2,7 +2,6 @@ package jade
 
 import (
 	"bytes"
-	"container/list"
 	"errors"
 	"fmt"
 	"github.com/go-floki/jade/parser"
@@ -16,10 +15,10 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var builtinFunctions = [...]string{
@@ -31,6 +30,7 @@ var builtinFunctions = [...]string{
 	"js",
 	"json",
 	"index",
+	"slice",
 	"html",
 	"unescaped",
 }
@@ -59,6 +59,9 @@ type Compiler struct {
 	buffer       *bytes.Buffer
 	tempvarIndex int
 	mixins       map[string]*parser.Mixin
+	loopDepth    int
+	loopUsed     bool
+	scopes       []map[string]bool
 }
 
 // Create and initialize a new Compiler
@@ -90,6 +93,27 @@ type Options struct {
 
     // Custom functions
     Funcs template.FuncMap
+	// Setting if invalid operations, like adding a struct to a number or dividing by zero, render an
+	// empty string instead of aborting the template execution with an error.
+	// Default: false
+	Lenient bool
+	// Location dates are converted to by the date helpers. Nil keeps the location of each date.
+	// Default: nil
+	Location *time.Location
+	// Layout used by the date helper when none is given, either a Go layout or a strftime style layout.
+	// Default: "2006-01-02"
+	DateLayout string
+	// Catalog used to translate messages, see the t and tn functions.
+	// Default: nil
+	Catalog *Catalog
+	// Filters applied to `:name` blocks at compile time, in addition to the built-in cdata, css,
+	// escape and markdown filters.
+	// Default: nil
+	Filters map[string]Filter
+	// Templates available to dynamic includes, like `include #{Widget.Template}`, keyed by name.
+	// CompileDir adds the templates it compiles, creating the map if it is nil.
+	// Default: nil
+	Templates map[string]*template.Template
 }
 
 // Used to provide options to directory compilation
@@ -100,7 +124,7 @@ type DirOptions struct {
 	Recursive bool
 }
 
-var DefaultOptions = Options{true, false, http.Dir(""), os.PathSeparator, nil}
+var DefaultOptions = Options{true, false, http.Dir(""), os.PathSeparator, nil, false, nil, DefaultDateLayout, nil, nil, nil}
 var DefaultDirOptions = DirOptions{".jade", true}
 
 // Parses and compiles the supplied jade template string. Returns corresponding Go Template (html/templates) instance.
@@ -137,6 +161,24 @@ func CompileFile(filename string, options Options) (*template.Template, error) {
 // If option for recursive is True, this parses every file of relevant extension
 // in all subdirectories. The key then is the path e.g: "layouts/layout"
 func CompileDir(dirname string, dopt DirOptions, opt Options) (map[string]*template.Template, error) {
+	// Compiled templates share the map of templates available to dynamic includes
+	if opt.Templates == nil {
+		opt.Templates = make(map[string]*template.Template)
+	}
+
+	compiled, err := compileDir(dirname, dopt, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, tmpl := range compiled {
+		opt.Templates[key] = tmpl
+	}
+
+	return compiled, nil
+}
+
+func compileDir(dirname string, dopt DirOptions, opt Options) (map[string]*template.Template, error) {
 	dir, err := opt.Fs.Open(dirname)
 	if err != nil {
 		return nil, err
@@ -157,7 +199,7 @@ func CompileDir(dirname string, dopt DirOptions, opt Options) (map[string]*templ
 		// If recursive is true and there's a subdirectory, recurse
 		if dopt.Recursive && file.IsDir() {
 			dirpath := path.Join(opt.PathSeparator, dirname, filename)
-			subcompiled, err := CompileDir(dirpath, dopt, opt)
+			subcompiled, err := compileDir(dirpath, dopt, opt)
 			if err != nil {
 				return nil, err
 			}
@@ -192,12 +234,15 @@ func (c *Compiler) Parse(input string) (err error) {
 	}()
 
 	parser, err := parser.StringParser(input)
-    parser.FileName(c.filename)
 
 	if err != nil {
 		return
 	}
 
+	parser.FileName(c.filename)
+	parser.FileSystem(c.Options.Fs)
+	parser.PathSeparator(c.Options.PathSeparator)
+
 	c.node = parser.Parse()
 	return
 }
@@ -239,7 +284,13 @@ func (c *Compiler) CompileWithTemplate(t *template.Template) (*template.Template
 		return nil, err
 	}
 
-	tpl, err := t.Funcs(FuncMap).Funcs(c.Options.Funcs).Parse(data)
+	t = t.Funcs(FuncMap).Funcs(dateFormatter{c.Options.Location, c.Options.DateLayout}.funcs()).
+		Funcs(translator{c.Options.Catalog}.funcs()).Funcs(includer{c.Options.Templates}.funcs())
+	if c.Options.Lenient {
+		t = t.Funcs(lenientFuncMap())
+	}
+
+	tpl, err := t.Funcs(c.Options.Funcs).Parse(data)
 	if err != nil {
 		return nil, err
 	}
@@ -258,8 +309,18 @@ func (c *Compiler) CompileWriter(out io.Writer) (err error) {
 	}()
 
 	c.buffer = new(bytes.Buffer)
+	c.scopes = nil
+	c.pushScope()
 	c.visit(c.node)
 
+	// Templates determine the locale first and unwrap data passed with WithLocale. Every template
+	// does so, as any of them may be executed with such data by WithLocale or a dynamic include.
+	if c.buffer.Len() > 0 {
+		body := c.buffer.String()
+		c.buffer.Reset()
+		c.write(`{{$__jade_locale := __jade_locale .}}{{range __jade_scope .}}` + body + `{{end}}`)
+	}
+
 	if c.buffer.Len() > 0 {
 		c.write("\n")
 	}
@@ -319,10 +380,16 @@ func (c *Compiler) visit(node parser.Node) {
 		c.visitBuffered(node.(*parser.Buffered))
 	case *parser.Assignment:
 		c.visitAssignment(node.(*parser.Assignment))
+	case *parser.Code:
+		c.visitCode(node.(*parser.Code))
 	case *parser.Mixin:
 		c.visitMixin(node.(*parser.Mixin))
 	case *parser.MixinCall:
 		c.visitMixinCall(node.(*parser.MixinCall))
+	case *parser.Filter:
+		c.visitFilter(node.(*parser.Filter))
+	case *parser.DynamicInclude:
+		c.visitDynamicInclude(node.(*parser.DynamicInclude))
 	}
 }
 
@@ -349,14 +416,56 @@ func (c *Compiler) tempvar() string {
 	return "$__jade_" + strconv.Itoa(c.tempvarIndex)
 }
 
+// Go templates scope variables to the enclosing if / range action, the compiler mirrors
+// these scopes to decide whether an assignment declares a new variable or updates an existing one.
+func (c *Compiler) pushScope() {
+	c.scopes = append(c.scopes, make(map[string]bool))
+}
+
+func (c *Compiler) popScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *Compiler) declare(name string) {
+	c.scopes[len(c.scopes)-1][name] = true
+}
+
+func (c *Compiler) isDeclared(name string) bool {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if c.scopes[i][name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Compiler) visitScopedBlock(block *parser.Block, vars ...string) {
+	c.pushScope()
+	defer c.popScope()
+
+	for _, name := range vars {
+		if len(name) > 0 {
+			c.declare(name)
+		}
+	}
+
+	c.visitBlock(block)
+}
+
 func (c *Compiler) escape(input string) string {
 	return strings.Replace(strings.Replace(input, `\`, `\\`, -1), `"`, `\"`, -1)
 }
 
 func (c *Compiler) visitBlock(block *parser.Block) {
 	for _, node := range block.Children {
-		if _, ok := node.(*parser.Text); !block.CanInline() && ok {
-			c.indent(0, true)
+		// Text and text with interpolated tags starts on a new line within blocks that are not inlined
+		if !block.CanInline() {
+			if _, ok := node.(*parser.Text); ok {
+				c.indent(0, true)
+			} else if inner, ok := node.(*parser.Block); ok && len(inner.Children) > 0 && inner.CanInline() {
+				c.indent(0, true)
+			}
 		}
 
 		c.visit(node)
@@ -385,10 +494,10 @@ func (c *Compiler) visitComment(comment *parser.Comment) {
 
 func (c *Compiler) visitCondition(condition *parser.Condition) {
 	c.write(`{{if ` + c.visitRawInterpolation(condition.Expression) + `}}`)
-	c.visitBlock(condition.Positive)
+	c.visitScopedBlock(condition.Positive)
 	if condition.Negative != nil {
 		c.write(`{{else}}`)
-		c.visitBlock(condition.Negative)
+		c.visitScopedBlock(condition.Negative)
 	}
 	c.write(`{{end}}`)
 }
@@ -398,12 +507,51 @@ func (c *Compiler) visitEach(each *parser.Each) {
 		return
 	}
 
+	collection := c.visitRawInterpolation(each.Expression)
+
+	// The body is compiled first so that the $loop state is only set up when it is actually referenced.
+	// Nested loops referring to $loop.Parent require the enclosing loop state as well.
+	outerUsed := c.loopUsed
+	c.loopUsed = false
+	c.loopDepth++
+
+	start := c.buffer.Len()
+	c.visitScopedBlock(each.Block, each.X, each.Y, "$loop")
+	body := string(c.buffer.Bytes()[start:])
+	c.buffer.Truncate(start)
+
+	used := c.loopUsed
+	c.loopDepth--
+	c.loopUsed = outerUsed || (used && c.loopDepth > 0)
+
+	loop := ""
+	if used {
+		loop = c.tempvar()
+		parent := "nil"
+		if c.loopDepth > 0 {
+			parent = "$loop"
+		}
+
+		if len(collection) > 0 && collection[0] != '$' {
+			name := c.tempvar()
+			c.write(`{{` + name + ` := ` + collection + `}}`)
+			collection = name
+		}
+
+		c.write(`{{` + loop + ` := __jade_loop ` + collection + ` ` + parent + `}}`)
+	}
+
 	if len(each.Y) == 0 {
-		c.write(`{{range ` + each.X + ` := ` + c.visitRawInterpolation(each.Expression) + `}}`)
+		c.write(`{{range ` + each.X + ` := ` + collection + `}}`)
 	} else {
-		c.write(`{{range ` + each.X + `, ` + each.Y + ` := ` + c.visitRawInterpolation(each.Expression) + `}}`)
+		c.write(`{{range ` + each.X + `, ` + each.Y + ` := ` + collection + `}}`)
 	}
-	c.visitBlock(each.Block)
+
+	if used {
+		c.write(`{{$loop := ` + loop + `.Next}}`)
+	}
+
+	c.write(body)
 	c.write(`{{end}}`)
 }
 
@@ -416,7 +564,29 @@ func (c *Compiler) visitBuffered(buff *parser.Buffered) {
 }
 
 func (c *Compiler) visitAssignment(assgn *parser.Assignment) {
-	c.write(`{{` + assgn.X + ` := ` + c.visitRawInterpolation(assgn.Expression) + `}}`)
+	value := c.visitRawInterpolation(assgn.Expression)
+	reassign := assgn.Mode == parser.AssignmentReassign
+
+	if assgn.Mode == parser.AssignmentDefault {
+		// Plain assignments update the variable of an enclosing scope if there is one
+		reassign = c.isDeclared(assgn.X)
+	} else if reassign && !c.isDeclared(assgn.X) {
+		panic("Assignment to undeclared variable " + assgn.X)
+	}
+
+	if reassign {
+		c.write(`{{` + assgn.X + ` = ` + value + `}}`)
+	} else {
+		c.declare(assgn.X)
+		c.write(`{{` + assgn.X + ` := ` + value + `}}`)
+	}
+}
+
+func (c *Compiler) visitCode(code *parser.Code) {
+	value := c.visitRawInterpolation(code.Expression)
+
+	// The value is evaluated and discarded, whether it is a temporary variable holding a call or not
+	c.write(`{{` + c.tempvar() + ` := ` + value + `}}`)
 }
 
 func (c *Compiler) visitTag(tag *parser.Tag) {
@@ -443,7 +613,7 @@ func (c *Compiler) visitTag(tag *parser.Tag) {
 			attr.value = ""
 		} else {
 			if item.IsRaw {
-				attr.value = item.Value
+				attr.value = c.visitTextInterpolation(item.Value)
 			} else {
 				attr.value = `{{"` + item.Value + `"}}`
 			}
@@ -473,7 +643,10 @@ func (c *Compiler) visitTag(tag *parser.Tag) {
 		}
 	}
 
-	c.indent(0, true)
+	// Tags interpolated into text are rendered in place
+	if !tag.IsInterpolated {
+		c.indent(0, true)
+	}
 	c.write("<" + tag.Name)
 
 	var attrNames []string
@@ -523,18 +696,90 @@ func (c *Compiler) visitTag(tag *parser.Tag) {
 	}
 }
 
-var textInterpolateRegexp = regexp.MustCompile(`#\{(.*?)\}`)
-var textEscapeRegexp = regexp.MustCompile(`\{\{(.*?)\}\}`)
+// Compiles text containing `#{}` and `!{}` interpolations into template source.
+func (c *Compiler) visitTextInterpolation(text string) string {
+	segments, err := splitInterpolations(text)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return c.visitSegments(segments)
+}
+
+func (c *Compiler) visitSegments(segments []textSegment) string {
+	var buf bytes.Buffer
+
+	for _, segment := range segments {
+		if !segment.expression {
+			// Template delimiters in literal text are printed as strings
+			buf.WriteString(strings.Replace(segment.value, "{{", `{{"{{"}}`, -1))
+		} else if segment.unescaped {
+			buf.WriteString(`{{unescaped ` + c.visitRawInterpolation(segment.value) + `}}`)
+		} else {
+			buf.WriteString(c.visitInterpolation(segment.value))
+		}
+	}
+
+	return buf.String()
+}
+
+// Returns the position of a byte offset within the value of a text node.
+func textPosition(txt *parser.Text, offset int) parser.SourcePosition {
+	pos := txt.SourcePosition
+	value := txt.Value
+
+	// Multi line text blocks are positioned at their last line
+	pos.LineNum -= strings.Count(value, "\n")
+	pos.LineNum += strings.Count(value[:offset], "\n")
+
+	if nl := strings.LastIndexByte(value[:offset], '\n'); nl >= 0 {
+		pos.ColNum = offset - nl
+	} else {
+		// The token of single line text includes its prefix, like `| `
+		pos.ColNum += pos.TokenLength - len(value) + offset
+	}
+
+	pos.TokenLength = 2
+	return pos
+}
 
 func (c *Compiler) visitText(txt *parser.Text) {
-	value := textEscapeRegexp.ReplaceAllStringFunc(txt.Value, func(value string) string {
-		return `{{"{{"}}` + value[2:len(value)-2] + `{{"}}"}}`
-	})
+	if txt.Verbatim {
+		c.visitLines(strings.Replace(txt.Value, "{{", `{{"{{"}}`, -1))
+		return
+	}
 
-	value = textInterpolateRegexp.ReplaceAllStringFunc(value, func(value string) string {
-		return c.visitInterpolation(value[2 : len(value)-1])
-	})
+	if txt.Raw {
+		c.visitLines(c.visitSegments(splitRawInterpolations(txt.Value)))
+		return
+	}
+
+	segments, err := splitInterpolations(txt.Value)
+	if ierr, ok := err.(*interpolationError); ok {
+		pos := textPosition(txt, ierr.Offset)
+		if len(pos.Filename) > 0 {
+			panic(fmt.Sprintf("Jade Error in <%s>: %v - Line: %d, Column: %d, Length: %d", pos.Filename, err, pos.LineNum, pos.ColNum, pos.TokenLength))
+		}
+		panic(fmt.Sprintf("Jade Error: %v - Line: %d, Column: %d, Length: %d", err, pos.LineNum, pos.ColNum, pos.TokenLength))
+	}
 
+	if txt.Translate {
+		key, args := translationKey(txt.Value)
+
+		call := `__jade_t $__jade_locale ` + strconv.Quote(key)
+		for _, arg := range args {
+			call += ` ` + c.visitRawInterpolation(arg)
+		}
+
+		c.write(`{{` + call + `}}`)
+		return
+	}
+
+	c.visitLines(c.visitSegments(segments))
+}
+
+// Writes compiled text, indenting each line after the first one.
+func (c *Compiler) visitLines(value string) {
 	lines := strings.Split(value, "\n")
 	for i := 0; i < len(lines); i++ {
 		c.write(lines[i])
@@ -546,6 +791,52 @@ func (c *Compiler) visitText(txt *parser.Text) {
 	}
 }
 
+func (c *Compiler) visitFilter(filter *parser.Filter) {
+	fn, ok := c.Options.Filters[filter.Name]
+	if !ok {
+		if fn, ok = builtinFilters[filter.Name]; !ok {
+			panic("Unknown filter: " + filter.Name)
+		}
+	}
+
+	result, err := fn(filter.Text, filter.Options)
+	if err != nil {
+		panic(fmt.Sprintf("Filter %s failed: %v", filter.Name, err))
+	}
+
+	// The result is written unchanged as a string, html/template would escape markup like CDATA
+	// sections. Only the first line is indented, indenting the others would change preformatted text.
+	c.indent(0, true)
+	c.write(`{{unescaped ` + strconv.Quote(result) + `}}`)
+}
+
+func (c *Compiler) visitDynamicInclude(include *parser.DynamicInclude) {
+	segments, err := splitInterpolations(include.Path)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	format, args := "", ""
+	for _, segment := range segments {
+		if segment.expression {
+			format += "%v"
+			args += " " + c.visitRawInterpolation(segment.value)
+		} else {
+			format += strings.Replace(segment.value, "%", "%%", -1)
+		}
+	}
+
+	// Included templates are rendered in the locale of the current execution, the root data $ carries
+	// the include depth. Lines after the first are indented by the includer when pretty printing.
+	indent := ""
+	if c.PrettyPrint {
+		indent = strings.Repeat("\t", c.indentLevel)
+	}
+
+	c.indent(0, true)
+	c.write(`{{__jade_include (printf ` + strconv.Quote(format) + args + `) ` + strconv.Quote(indent) + ` $__jade_locale $ .}}`)
+}
+
 func (c *Compiler) visitInterpolation(value string) string {
 	return `{{` + c.visitRawInterpolation(value) + `}}`
 }
@@ -556,18 +847,7 @@ func (c *Compiler) visitJSInterpolation(value string) string {
 
 func (c *Compiler) visitRawInterpolation(value string) string {
 	value = strings.Replace(value, "$", "__DOLLAR__", -1)
-	expr, err := gp.ParseExpr(value)
-
-    if err != nil {
-        // dumb hack to parse single-quoted strings
-        valueLen := len(value)
-        bValue := []byte(value)
-        if bValue[0] == '\'' && bValue[valueLen - 1] == '\'' {
-            bValue[0] = '"'
-            bValue[valueLen - 1] = '"'
-            expr, err = gp.ParseExpr(string(bValue))
-        }
-    }
+	expr, err := gp.ParseExpr(c.rewriteExpression(value))
 
 	if err != nil {
         panic(fmt.Sprintf("Unable to parse expression: %s", value))
@@ -577,6 +857,38 @@ func (c *Compiler) visitRawInterpolation(value string) string {
 	return value
 }
 
+func isBuiltinFunction(name string) bool {
+	for _, fname := range builtinFunctions {
+		if fname == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Runtime functions a bare name refers to, like `upper` in `Name | upper`. The other runtime helpers
+// and the builtin functions of Go templates are only resolved when they are called, piped with
+// arguments or piped with `|>`, so that data fields with names like title or first stay accessible.
+var bareFunctions = map[string]bool{
+	"json":          true,
+	"unescaped":     true,
+	"safeAttribute": true,
+	"safeJS":        true,
+	"upper":         true,
+	"lower":         true,
+}
+
+// Reports whether a bare name refers to a function rather than a data field: custom functions and
+// the runtime functions in bareFunctions.
+func (c *Compiler) isBareFunction(name string) bool {
+	if _, ok := c.Options.Funcs[name]; ok {
+		return true
+	}
+
+	return bareFunctions[name]
+}
+
 func (c *Compiler) hasFunctionWithName(name string) bool {
     if _, inCustom := c.Options.Funcs[name]; inCustom {
         return true
@@ -588,203 +900,215 @@ func (c *Compiler) hasFunctionWithName(name string) bool {
 }
 
 func (c *Compiler) visitExpression(outerexpr ast.Expr) string {
-	stack := list.New()
-
-	pop := func() string {
-		if stack.Front() == nil {
-			return ""
-		}
-
-		val := stack.Front().Value.(string)
-		stack.Remove(stack.Front())
-		return val
+	// Stores the value of an action in a temporary variable and returns the variable name
+	assign := func(value string) string {
+		name := c.tempvar()
+		c.write(`{{` + name + ` := ` + value + `}}`)
+		return name
 	}
 
-	var exec func(ast.Expr)
+	var exec func(ast.Expr) string
 
-	exec = func(expr ast.Expr) {
-		switch expr.(type) {
+	exec = func(expr ast.Expr) string {
+		switch expr := expr.(type) {
 		case *ast.BinaryExpr:
-			{
-				be := expr.(*ast.BinaryExpr)
-
-				exec(be.Y)
-				exec(be.X)
-
-                name := c.tempvar()
-
-                switch be.Op {
-                    case gt.OR:
-                    c.write(`{{` + name + ` := ` + pop() + ` | ` + pop() + `}}`)
-                    stack.PushFront(name)
-                    return
-                }
-
-                negate := false
-				c.write(`{{` + name + ` := `)
-
-				switch be.Op {
-				case gt.ADD:
-					c.write("__jade_add ")
-				case gt.SUB:
-					c.write("__jade_sub ")
-				case gt.MUL:
-					c.write("__jade_mul ")
-				case gt.QUO:
-					c.write("__jade_quo ")
-				case gt.REM:
-					c.write("__jade_rem ")
-				case gt.LAND:
-					c.write("and ")
-				case gt.LOR:
-					c.write("or ")
-				case gt.EQL:
-					c.write("__jade_eql ")
-				case gt.NEQ:
-					c.write("__jade_eql ")
-					negate = true
-				case gt.LSS:
-					c.write("__jade_lss ")
-				case gt.GTR:
-					c.write("__jade_gtr ")
-				case gt.LEQ:
-					c.write("__jade_gtr ")
-					negate = true
-				case gt.GEQ:
-					c.write("__jade_lss ")
-					negate = true
-				default:
-					panic("Unexpected operator: '" + be.Op.String() + "'")
-				}
-
-				c.write(pop() + ` ` + pop() + `}}`)
+			// Logical operators short-circuit, the right operand is only evaluated when it decides the result
+			if expr.Op == gt.LAND || expr.Op == gt.LOR {
+				name := assign(exec(expr.X))
 
-				if !negate {
-					stack.PushFront(name)
+				if expr.Op == gt.LAND {
+					c.write(`{{if ` + name + `}}`)
 				} else {
-					negname := c.tempvar()
-					c.write(`{{` + negname + ` := not ` + name + `}}`)
-					stack.PushFront(negname)
+					c.write(`{{if not ` + name + `}}`)
 				}
+
+				c.write(`{{` + name + ` = ` + exec(expr.Y) + `}}{{end}}`)
+				return name
 			}
+
+			x := exec(expr.X)
+			y := exec(expr.Y)
+
+			var fn string
+			negate := false
+
+			switch expr.Op {
+			case gt.ADD:
+				fn = "__jade_add"
+			case gt.SUB:
+				fn = "__jade_sub"
+			case gt.MUL:
+				fn = "__jade_mul"
+			case gt.QUO:
+				fn = "__jade_quo"
+			case gt.REM:
+				fn = "__jade_rem"
+			case gt.OR:
+				fn = "__jade_or"
+			case gt.AND:
+				fn = "__jade_and"
+			case gt.XOR:
+				fn = "__jade_xor"
+			case gt.AND_NOT:
+				fn = "__jade_andnot"
+			case gt.SHL:
+				fn = "__jade_shl"
+			case gt.SHR:
+				fn = "__jade_shr"
+			case gt.EQL:
+				fn = "__jade_eql"
+			case gt.NEQ:
+				fn = "__jade_eql"
+				negate = true
+			case gt.LSS:
+				fn = "__jade_lss"
+			case gt.GTR:
+				fn = "__jade_gtr"
+			case gt.LEQ:
+				fn = "__jade_gtr"
+				negate = true
+			case gt.GEQ:
+				fn = "__jade_lss"
+				negate = true
+			default:
+				panic("Unexpected operator: '" + expr.Op.String() + "'")
+			}
+
+			name := assign(fn + ` ` + x + ` ` + y)
+			if negate {
+				return assign(`not ` + name)
+			}
+
+			return name
 		case *ast.UnaryExpr:
-			{
-				ue := expr.(*ast.UnaryExpr)
+			x := exec(expr.X)
+
+			switch expr.Op {
+			case gt.SUB:
+				return assign(`__jade_minus ` + x)
+			case gt.ADD:
+				return assign(`__jade_plus ` + x)
+			case gt.NOT:
+				return assign(`not ` + x)
+			case gt.XOR:
+				return assign(`__jade_complement ` + x)
+			}
 
-				exec(ue.X)
+			panic("Unexpected operator: '" + expr.Op.String() + "'")
+		case *ast.IndexExpr:
+			x := exec(expr.X)
+			return assign(`index ` + x + ` ` + exec(expr.Index))
+		case *ast.SliceExpr:
+			args := []string{exec(expr.X), "0"}
 
-				name := c.tempvar()
-				c.write(`{{` + name + ` := `)
-
-				switch ue.Op {
-				case gt.SUB:
-					c.write("__jade_minus ")
-				case gt.ADD:
-					c.write("__jade_plus ")
-				case gt.NOT:
-					c.write("not ")
-				default:
-					panic("Unexpected operator: '" + ue.Op.String() + "'")
-				}
+			if expr.Low != nil {
+				args[1] = exec(expr.Low)
+			}
+
+			if expr.High != nil {
+				args = append(args, exec(expr.High))
+			}
 
-				c.write(pop() + `}}`)
-				stack.PushFront(name)
+			if expr.Max != nil {
+				args = append(args, exec(expr.Max))
 			}
+
+			return assign(`slice ` + strings.Join(args, ` `))
 		case *ast.ParenExpr:
-			exec(expr.(*ast.ParenExpr).X)
+			return exec(expr.X)
 		case *ast.BasicLit:
-			stack.PushFront(expr.(*ast.BasicLit).Value)
+			return expr.Value
 		case *ast.Ident:
-			name := expr.(*ast.Ident).Name
-			if len(name) >= len("__DOLLAR__") && name[:len("__DOLLAR__")] == "__DOLLAR__" {
+			name := expr.Name
+
+			if strings.HasPrefix(name, "__DOLLAR__") {
 				if name == "__DOLLAR__" {
-					stack.PushFront(`.`)
-				} else {
-					stack.PushFront(`$` + expr.(*ast.Ident).Name[len("__DOLLAR__"):])
+					return `.`
 				}
-			} else {
-				rname := expr.(*ast.Ident).Name
-				switch rname {
-				case "nil":
-					stack.PushFront(rname)
-				default:
-                    if c.hasFunctionWithName(rname) {
-                        stack.PushFront(rname)
-                    } else {
-                        stack.PushFront(`.` + rname)
-                    }
+
+				if name == "__DOLLAR__loop" {
+					c.loopUsed = true
 				}
+
+				return `$` + name[len("__DOLLAR__"):]
+			}
+
+			switch name {
+			case "nil", "true", "false":
+				return name
+			}
+
+			// The same rule as for pipe stages, other helpers are only resolved when called
+			if c.isBareFunction(name) {
+				return name
 			}
+
+			return `.` + name
 		case *ast.SelectorExpr:
-			se := expr.(*ast.SelectorExpr)
-			exec(se.X)
-			x := pop()
+			x := exec(expr.X)
 
 			if x == "." {
 				x = ""
 			}
 
-			name := c.tempvar()
-			c.write(`{{` + name + ` := ` + x + `.` + se.Sel.Name + `}}`)
-			stack.PushFront(name)
-
+			return assign(x + `.` + expr.Sel.Name)
 		case *ast.CallExpr:
-			ce := expr.(*ast.CallExpr)
-
-			for i := len(ce.Args) - 1; i >= 0; i-- {
-				exec(ce.Args[i])
-			}
+			// Conditional operators only evaluate the operands they yield
+			if ident, ok := expr.Fun.(*ast.Ident); ok && ident.Name == intrinsicCond {
+				cond := exec(expr.Args[0])
 
-			name := c.tempvar()
-			builtin := false
-
-			if ident, ok := ce.Fun.(*ast.Ident); ok {
-                for _, fname := range builtinFunctions {
-					if fname == ident.Name {
-						builtin = true
-						break
-					}
-				}
+				name := c.tempvar()
+				c.write(`{{` + name + ` := ""}}{{if ` + cond + `}}`)
+				c.write(`{{` + name + ` = ` + exec(expr.Args[1]) + `}}{{else}}`)
+				c.write(`{{` + name + ` = ` + exec(expr.Args[2]) + `}}{{end}}`)
+				return name
+			} else if ok && ident.Name == intrinsicCoalesce {
+				name := assign(exec(expr.Args[0]))
+
+				c.write(`{{if __jade_nil ` + name + `}}`)
+				c.write(`{{` + name + ` = ` + exec(expr.Args[1]) + `}}{{end}}`)
+				return name
 			}
 
-			if builtin {
-                // @todo check what's going on here..
-				stack.PushFront(ce.Fun.(*ast.Ident).Name)
-				c.write("{{" + name + " := " + pop())
-
-			} else {
-                if se, ok := ce.Fun.(*ast.SelectorExpr); ok {
-					exec(se.X)
-					x := pop()
+			// The function or method receiver is evaluated first, followed by the arguments from left to right
+			var fn string
 
-					if x == "." {
-						x = ""
-					}
+			switch fun := expr.Fun.(type) {
+			case *ast.SelectorExpr:
+				receiver := exec(fun.X)
 
-                    c.write("{{" + name + " := " + x + "." + se.Sel.Name + " ")
+				if receiver == "." {
+					receiver = ""
+				}
 
+				fn = receiver + `.` + fun.Sel.Name
+			case *ast.Ident:
+				if (fun.Name == "t" || fun.Name == "tn") && !c.hasFunctionWithName(fun.Name) {
+					// Translations are rendered in the locale of the current execution
+					fn = `__jade_` + fun.Name + ` $__jade_locale`
+				} else if isBuiltinFunction(fun.Name) || c.hasFunctionWithName(fun.Name) {
+					fn = fun.Name
+				} else if value := exec(fun); value[0] == '$' {
+					// Function values stored in variables
+					fn = `call ` + value
 				} else {
-					exec(ce.Fun)
-					c.write("{{" + name + " := " + pop())
+					fn = value
 				}
-
+			default:
+				fn = `call ` + exec(fun)
 			}
 
-			for i := 0; i < len(ce.Args); i++ {
-				c.write(` `)
-				c.write(pop())
+			for _, arg := range expr.Args {
+				fn += ` ` + exec(arg)
 			}
 
-			c.write(`}}`)
-
-			stack.PushFront(name)
-		default:
-			panic("Unable to parse expression. Unsupported: " + reflect.TypeOf(expr).String())
+			return assign(fn)
 		}
+
+		panic("Unable to parse expression. Unsupported: " + reflect.TypeOf(expr).String())
 	}
 
-	exec(outerexpr)
-	return pop()
+	return exec(outerexpr)
 }
 
 func (c *Compiler) visitMixin(mixin *parser.Mixin) {
@@ -794,6 +1118,7 @@ func (c *Compiler) visitMixin(mixin *parser.Mixin) {
 func (c *Compiler) visitMixinCall(mixinCall *parser.MixinCall) {
 	mixin := c.mixins[mixinCall.Name]
 	for i, arg := range mixin.Args {
+		c.declare(arg)
 		c.write(fmt.Sprintf(`{{%s := %s}}`, arg, c.visitRawInterpolation(mixinCall.Args[i])))
 	}
 	c.visitBlock(mixin.Block)
@@ -0,0 +1,179 @@
+package jade
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Layout used by the date helper when no layout is given and Options.DateLayout is empty.
+const DefaultDateLayout = "2006-01-02"
+
+// Formats dates in a fixed location and with a default layout, both taken from the compiler options.
+type dateFormatter struct {
+	location *time.Location
+	layout   string
+}
+
+func (f dateFormatter) funcs() template.FuncMap {
+	return template.FuncMap{
+		"date":     f.date,
+		"isoDate":  f.isoDate,
+		"timeAgo":  f.timeAgo,
+		"relative": f.timeAgo,
+	}
+}
+
+// Converts a template value into a time. Accepts time.Time, Unix timestamps in seconds and strings
+// in RFC 3339 or `2006-01-02` format.
+func (f dateFormatter) toTime(name string, x interface{}) (time.Time, error) {
+	var t time.Time
+
+	switch v := x.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return t, fmt.Errorf("%s: nil time", name)
+		}
+		t = *v
+	case string:
+		var err error
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			if t, err = time.Parse("2006-01-02", v); err != nil {
+				return t, fmt.Errorf("%s: unable to parse %q as a date", name, v)
+			}
+		}
+	default:
+		n, ok := toNumber(x)
+		if !ok || !n.isInteger() || reflect.TypeOf(x).Kind() == reflect.Bool {
+			return t, fmt.Errorf("%s: %T is not a date", name, x)
+		}
+		t = time.Unix(n.bigInt().Int64(), 0)
+	}
+
+	if f.location != nil {
+		t = t.In(f.location)
+	}
+
+	return t, nil
+}
+
+// Formats a date with the default layout or the given one: `date "Jan 2, 2006" CreatedAt`. Layouts
+// containing a `%` are strftime style layouts: `date "%d.%m.%Y" CreatedAt`.
+func (f dateFormatter) date(args ...interface{}) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", fmt.Errorf("date: expected a date and an optional layout")
+	}
+
+	t, err := f.toTime("date", args[len(args)-1])
+	if err != nil {
+		return "", err
+	}
+
+	layout := f.layout
+	if len(args) == 2 {
+		layout = runtime_string(args[0])
+	}
+	if len(layout) == 0 {
+		layout = DefaultDateLayout
+	}
+
+	if strings.ContainsRune(layout, '%') {
+		return strftime(t, layout)
+	}
+
+	return t.Format(layout), nil
+}
+
+func (f dateFormatter) isoDate(x interface{}) (string, error) {
+	t, err := f.toTime("isoDate", x)
+	if err != nil {
+		return "", err
+	}
+
+	return t.Format(time.RFC3339), nil
+}
+
+var relativeUnits = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"year", 365 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"day", 24 * time.Hour},
+	{"hour", time.Hour},
+	{"minute", time.Minute},
+}
+
+// Describes a date relative to the current time: "3 hours ago", "in 2 days" or "just now".
+func (f dateFormatter) timeAgo(x interface{}) (string, error) {
+	t, err := f.toTime("timeAgo", x)
+	if err != nil {
+		return "", err
+	}
+
+	diff := time.Since(t)
+	future := diff < 0
+	if future {
+		diff = -diff
+	}
+
+	for _, unit := range relativeUnits {
+		if diff < unit.duration {
+			continue
+		}
+
+		count := int(math.Round(float64(diff) / float64(unit.duration)))
+		text := fmt.Sprintf("%d %s", count, unit.name)
+		if count != 1 {
+			text += "s"
+		}
+
+		if future {
+			return "in " + text, nil
+		}
+		return text + " ago", nil
+	}
+
+	return "just now", nil
+}
+
+// Go layouts of strftime directives.
+var strftimeDirectives = map[byte]string{
+	'a': "Mon", 'A': "Monday", 'b': "Jan", 'B': "January", 'h': "Jan",
+	'd': "02", 'e': "_2", 'm': "01", 'y': "06", 'Y': "2006", 'j': "002",
+	'H': "15", 'I': "03", 'l': "3", 'M': "04", 'S': "05", 'p': "PM",
+	'Z': "MST", 'z': "-0700", 'F': "2006-01-02", 'T': "15:04:05", 'D': "01/02/06", 'R': "15:04",
+}
+
+// Formats a time with a strftime style layout like `%Y-%m-%d %H:%M`.
+func strftime(t time.Time, layout string) (string, error) {
+	var buf strings.Builder
+
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			buf.WriteByte(layout[i])
+			continue
+		}
+
+		if i+1 == len(layout) {
+			return "", fmt.Errorf("date: layout %q ends with %%", layout)
+		}
+
+		i++
+		if layout[i] == '%' {
+			buf.WriteByte('%')
+		} else if directive, ok := strftimeDirectives[layout[i]]; ok {
+			buf.WriteString(t.Format(directive))
+		} else {
+			return "", fmt.Errorf("date: unknown directive %%%c in layout %q", layout[i], layout)
+		}
+	}
+
+	return buf.String(), nil
+}
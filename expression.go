@@ -0,0 +1,472 @@
+package jade
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// Template expressions are parsed with the Go expression parser. Syntax that Go does not know about,
+// such as pipes, conditional operators, list / map literals and single quoted strings, is rewritten into plain Go expressions
+// (mostly calls to compiler intrinsics) before the expression is handed over to the parser.
+
+const (
+	intrinsicCond     = "__jade_cond"
+	intrinsicCoalesce = "__jade_coalesce"
+	intrinsicList     = "__jade_list"
+	intrinsicDict     = "__jade_dict"
+)
+
+var rgxIdentifier = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// Returns the index of the character closing the string literal starting at index i.
+func skipString(value string, i int) int {
+	quote := value[i]
+
+	for j := i + 1; j < len(value); j++ {
+		if value[j] == '\\' && quote != '`' {
+			j++
+		} else if value[j] == quote {
+			return j
+		}
+	}
+
+	return len(value) - 1
+}
+
+// Calls fn for every character of value which is not part of a string literal, along with the
+// bracket nesting depth at that position. Brackets are reported at the depth of their enclosing
+// expression. The walk stops as soon as fn returns false.
+func walkExpression(value string, fn func(i, depth int) bool) {
+	depth := 0
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"', '\'', '`':
+			i = skipString(value, i)
+			continue
+		case '(', '[', '{':
+			if !fn(i, depth) {
+				return
+			}
+			depth++
+			continue
+		case ')', ']', '}':
+			depth--
+		}
+
+		if !fn(i, depth) {
+			return
+		}
+	}
+}
+
+// Finds the outermost `cond ? a : b` expression and returns the positions of its `?` and `:`.
+func findConditional(value string) (int, int) {
+	question, colon, nested := -1, -1, 0
+
+	walkExpression(value, func(i, depth int) bool {
+		if depth != 0 {
+			return true
+		}
+
+		switch value[i] {
+		case '?':
+			if (i+1 < len(value) && value[i+1] == '?') || (i > 0 && value[i-1] == '?') {
+				return true
+			}
+
+			if question < 0 {
+				question = i
+			} else {
+				nested++
+			}
+		case ':':
+			if question < 0 {
+				return true
+			}
+
+			if nested > 0 {
+				nested--
+			} else {
+				colon = i
+				return false
+			}
+		}
+
+		return true
+	})
+
+	if colon < 0 {
+		return -1, -1
+	}
+
+	return question, colon
+}
+
+// Returns the position of the last top level `??` operator.
+func findCoalesce(value string) int {
+	pos := -1
+
+	walkExpression(value, func(i, depth int) bool {
+		if depth == 0 && value[i] == '?' && i+1 < len(value) && value[i+1] == '?' {
+			pos = i
+		}
+
+		return true
+	})
+
+	return pos
+}
+
+// Splits value at top level commas.
+func splitArguments(value string) []string {
+	var args []string
+	start := 0
+
+	walkExpression(value, func(i, depth int) bool {
+		if depth == 0 && value[i] == ',' {
+			args = append(args, value[start:i])
+			start = i + 1
+		}
+
+		return true
+	})
+
+	return append(args, value[start:])
+}
+
+// Splits value at top level white space.
+func splitFields(value string) []string {
+	var fields []string
+	start := 0
+
+	walkExpression(value+" ", func(i, depth int) bool {
+		if depth == 0 && (i == len(value) || value[i] == ' ' || value[i] == '\t') {
+			if i > start {
+				fields = append(fields, value[start:i])
+			}
+			start = i + 1
+		}
+
+		return true
+	})
+
+	return fields
+}
+
+var rgxPipeStage = regexp.MustCompile(`^\s*([A-Za-z_]\w*)\s*(.*?)\s*$`)
+
+// Matches the start of the arguments of a pipe stage, as opposed to the operators of an expression.
+var rgxPipeArguments = regexp.MustCompile("^[(\"'`$\\[{\\w]")
+
+// Finds the last top level pipe operator and returns its position and length. `|>` always denotes
+// a pipe. A single `|` is a pipe when it is followed by a function a bare name refers to (see
+// isBareFunction), or by any other function along with arguments, as in `Posts | sortBy "Title"`.
+// Otherwise it is the bitwise or operator, so that `Flags | first` does not change its meaning when
+// a helper named first exists.
+func (c *Compiler) findPipe(value string) (int, int) {
+	var candidates []int
+
+	walkExpression(value, func(i, depth int) bool {
+		if depth == 0 && value[i] == '|' {
+			prev := i > 0 && value[i-1] == '|'
+			next := i+1 < len(value) && (value[i+1] == '|' || value[i+1] == '=')
+
+			if !prev && !next {
+				candidates = append(candidates, i)
+			}
+		}
+
+		return true
+	})
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		pos := candidates[i]
+
+		if pos+1 < len(value) && value[pos+1] == '>' {
+			return pos, 2
+		}
+
+		if sm := rgxPipeStage.FindStringSubmatch(value[pos+1:]); len(sm) != 0 {
+			if c.isBareFunction(sm[1]) {
+				return pos, 1
+			}
+
+			if rgxPipeArguments.MatchString(sm[2]) && (isBuiltinFunction(sm[1]) || c.hasFunctionWithName(sm[1])) {
+				return pos, 1
+			}
+		}
+	}
+
+	return -1, 0
+}
+
+// Rewrites a pipe stage into a call passing the piped value as the last argument. Stages are a function
+// name optionally followed by either a parenthesized argument list or white space separated arguments.
+func (c *Compiler) rewritePipeStage(stage, input string) string {
+	sm := rgxPipeStage.FindStringSubmatch(stage)
+	if len(sm) == 0 {
+		panic("Invalid pipe stage: " + strings.TrimSpace(stage))
+	}
+
+	var args []string
+	if rest := sm[2]; len(rest) > 0 && rest[0] == '(' && rest[len(rest)-1] == ')' {
+		if inner := strings.TrimSpace(rest[1 : len(rest)-1]); len(inner) > 0 {
+			args = splitArguments(inner)
+		}
+	} else {
+		args = splitFields(rest)
+	}
+
+	for i := range args {
+		args[i] = c.rewriteExpression(args[i])
+	}
+
+	return sm[1] + "(" + strings.Join(append(args, "("+input+")"), ", ") + ")"
+}
+
+// Converts a single quoted string literal into a Go string literal.
+func quoteString(literal string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+
+	for i := 1; i < len(literal)-1; i++ {
+		switch ch := literal[i]; ch {
+		case '\\':
+			if i+1 < len(literal)-1 && literal[i+1] == '\'' {
+				buf.WriteByte('\'')
+			} else if i+1 < len(literal)-1 {
+				buf.WriteByte(ch)
+				buf.WriteByte(literal[i+1])
+			}
+			i++
+		case '"':
+			buf.WriteString(`\"`)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+func (c *Compiler) rewriteExpression(value string) string {
+	if pos, size := c.findPipe(value); pos >= 0 {
+		return c.rewritePipeStage(value[pos+size:], c.rewriteExpression(value[:pos]))
+	}
+
+	if question, colon := findConditional(value); question >= 0 {
+		return intrinsicCond + "(" + c.rewriteExpression(value[:question]) + ", " +
+			c.rewriteExpression(value[question+1:colon]) + ", " +
+			c.rewriteExpression(value[colon+1:]) + ")"
+	}
+
+	if pos := findCoalesce(value); pos >= 0 {
+		return intrinsicCoalesce + "(" + c.rewriteExpression(value[:pos]) + ", " + c.rewriteExpression(value[pos+2:]) + ")"
+	}
+
+	return c.rewriteNested(value)
+}
+
+// Reports whether the expression preceding a bracket ends with an operand, which makes the bracket
+// an index, slice or call rather than the start of a literal.
+func followsOperand(value string) bool {
+	value = strings.TrimRight(value, " \t")
+	if len(value) == 0 {
+		return false
+	}
+
+	ch := value[len(value)-1]
+	return ch == '_' || ch == ')' || ch == ']' || ch == '}' || ch == '"' || ch == '\'' || ch == '`' ||
+		(ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// Rewrites `key: value` map literal entries into alternating key and value arguments. Bare
+// identifiers are used as string keys.
+func (c *Compiler) rewriteDictEntries(entries []string) []string {
+	args := make([]string, 0, len(entries)*2)
+
+	for _, entry := range entries {
+		colon := -1
+		walkExpression(entry, func(i, depth int) bool {
+			if depth == 0 && entry[i] == ':' {
+				colon = i
+				return false
+			}
+			return true
+		})
+
+		if colon < 0 {
+			panic("Missing key in map literal entry: " + strings.TrimSpace(entry))
+		}
+
+		key := strings.TrimSpace(entry[:colon])
+		if rgxIdentifier.MatchString(key) {
+			key = `"` + key + `"`
+		} else {
+			key = c.rewriteExpression(key)
+		}
+
+		args = append(args, key, c.rewriteExpression(entry[colon+1:]))
+	}
+
+	return args
+}
+
+// Rewrites string literals and the contents of every bracketed sub expression of value.
+func (c *Compiler) rewriteNested(value string) string {
+	var buf bytes.Buffer
+
+	for i := 0; i < len(value); i++ {
+		switch ch := value[i]; ch {
+		case '"', '`':
+			end := skipString(value, i)
+			buf.WriteString(value[i : end+1])
+			i = end
+		case '\'':
+			end := skipString(value, i)
+			buf.WriteString(quoteString(value[i : end+1]))
+			i = end
+		case '(', '[', '{':
+			end := len(value)
+			walkExpression(value[i:], func(j, depth int) bool {
+				if depth == 0 && j > 0 && strings.IndexByte(")]}", value[i+j]) >= 0 {
+					end = i + j
+					return false
+				}
+				return true
+			})
+
+			inner := value[i+1 : end]
+			args := splitArguments(inner)
+			if len(strings.TrimSpace(inner)) == 0 {
+				args = nil
+			}
+
+			// Brackets which do not follow an operand start a list or map literal
+			literal := ch != '(' && !followsOperand(value[:i])
+
+			if literal && ch == '{' {
+				args = c.rewriteDictEntries(args)
+			} else {
+				for k := range args {
+					args[k] = c.rewriteExpression(args[k])
+				}
+			}
+
+			if literal && ch == '[' {
+				buf.WriteString(intrinsicList + "(" + strings.Join(args, ", ") + ")")
+			} else if literal {
+				buf.WriteString(intrinsicDict + "(" + strings.Join(args, ", ") + ")")
+			} else {
+				buf.WriteByte(ch)
+				buf.WriteString(strings.Join(args, ","))
+				if end < len(value) {
+					buf.WriteByte(value[end])
+				}
+			}
+			i = end
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+
+	return buf.String()
+}
+
+// A part of a text containing interpolations: either literal text or the expression of an `#{}`
+// (escaped) or `!{}` (unescaped) interpolation.
+type textSegment struct {
+	value      string
+	expression bool
+	unescaped  bool
+}
+
+// Reported for interpolations without a closing brace, Offset is the position of the interpolation in the text.
+type interpolationError struct {
+	Offset int
+}
+
+func (e *interpolationError) Error() string {
+	return "Unterminated interpolation"
+}
+
+// Returns the index of the brace closing the interpolation whose expression starts at index i. Braces
+// nested in the expression and braces within string literals are skipped.
+func findInterpolationEnd(text string, i int) int {
+	depth := 1
+
+	for ; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'', '`':
+			i = skipString(text, i)
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Splits text into literal text and interpolations. A backslash in front of an interpolation prints
+// it literally: `\#{Name}` becomes `#{Name}`.
+func splitInterpolations(text string) ([]textSegment, error) {
+	var segments []textSegment
+	var literal bytes.Buffer
+
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+
+		if ch == '\\' && i+2 < len(text) && (text[i+1] == '#' || text[i+1] == '!') && text[i+2] == '{' {
+			// Escaped interpolations are printed without the backslash
+			literal.WriteString(text[i+1 : i+3])
+			i += 2
+			continue
+		}
+
+		if (ch != '#' && ch != '!') || i+1 >= len(text) || text[i+1] != '{' {
+			literal.WriteByte(ch)
+			continue
+		}
+
+		end := findInterpolationEnd(text, i+2)
+		if end < 0 {
+			return nil, &interpolationError{i}
+		}
+
+		if literal.Len() > 0 {
+			segments = append(segments, textSegment{value: literal.String()})
+			literal.Reset()
+		}
+
+		segments = append(segments, textSegment{value: text[i+2 : end], expression: true, unescaped: ch == '!'})
+		i = end
+	}
+
+	if literal.Len() > 0 {
+		segments = append(segments, textSegment{value: literal.String()})
+	}
+
+	return segments, nil
+}
+
+// Splits raw text, like the contents of script and style tags, in which an interpolation without its
+// closing brace is literal text: `var open = "#{";`
+func splitRawInterpolations(text string) []textSegment {
+	segments, err := splitInterpolations(text)
+	ierr, ok := err.(*interpolationError)
+	if !ok {
+		return segments
+	}
+
+	// The text before the unterminated interpolation has no errors
+	segments, _ = splitInterpolations(text[:ierr.Offset])
+	segments = append(segments, textSegment{value: text[ierr.Offset : ierr.Offset+2]})
+	return append(segments, splitRawInterpolations(text[ierr.Offset+2:])...)
+}
@@ -0,0 +1,299 @@
+package jade
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-floki/jade/parser"
+	"github.com/go-floki/jade/path"
+	"go/ast"
+	gp "go/parser"
+	gt "go/token"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message is a translatable message found in a template.
+type Message struct {
+	// Key of the message, the text in the source language
+	Key string
+	// Plural key of messages translated with tn, empty otherwise
+	Plural string
+	// Positions the message is used at
+	Positions []parser.SourcePosition
+}
+
+// Collects the messages of parsed templates.
+type extractor struct {
+	compiler *Compiler
+	messages map[string]*Message
+	order    []*Message
+}
+
+func newExtractor(options Options) *extractor {
+	comp := New()
+	comp.Options = options
+
+	return &extractor{compiler: comp, messages: make(map[string]*Message)}
+}
+
+func (e *extractor) add(key, plural string, pos parser.SourcePosition) {
+	if len(key) == 0 {
+		return
+	}
+
+	msg, ok := e.messages[key]
+	if !ok {
+		msg = &Message{Key: key}
+		e.messages[key] = msg
+		e.order = append(e.order, msg)
+	}
+
+	if len(plural) > 0 {
+		msg.Plural = plural
+	}
+
+	for _, known := range msg.Positions {
+		if known.Filename == pos.Filename && known.LineNum == pos.LineNum {
+			return
+		}
+	}
+
+	msg.Positions = append(msg.Positions, pos)
+}
+
+// Finds calls of t and tn with literal keys in an expression.
+func (e *extractor) expression(value string, pos parser.SourcePosition) {
+	var expr ast.Expr
+
+	func() {
+		// Invalid expressions are reported when the template is compiled
+		defer func() { recover() }()
+		expr, _ = gp.ParseExpr(e.compiler.rewriteExpression(strings.Replace(value, "$", "__DOLLAR__", -1)))
+	}()
+
+	if expr == nil {
+		return
+	}
+
+	literal := func(arg ast.Expr) string {
+		if lit, ok := arg.(*ast.BasicLit); ok && lit.Kind == gt.STRING {
+			if str, err := strconv.Unquote(lit.Value); err == nil {
+				return str
+			}
+		}
+		return ""
+	}
+
+	ast.Inspect(expr, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		if fun, ok := call.Fun.(*ast.Ident); ok && !e.compiler.hasFunctionWithName(fun.Name) {
+			if fun.Name == "t" && len(call.Args) > 0 {
+				e.add(literal(call.Args[0]), "", pos)
+			} else if fun.Name == "tn" && len(call.Args) > 1 {
+				e.add(literal(call.Args[0]), literal(call.Args[1]), pos)
+			}
+		}
+
+		return true
+	})
+}
+
+func (e *extractor) text(value string, pos parser.SourcePosition) {
+	// Invalid interpolations are reported when the template is compiled
+	segments, _ := splitInterpolations(value)
+
+	for _, segment := range segments {
+		if segment.expression {
+			e.expression(segment.value, pos)
+		}
+	}
+}
+
+func (e *extractor) visit(node parser.Node) {
+	switch node := node.(type) {
+	case *parser.Block:
+		if node != nil {
+			for _, child := range node.Children {
+				e.visit(child)
+			}
+		}
+	case *parser.NamedBlock:
+		e.visit(&node.Block)
+	case *parser.Text:
+		if _, err := splitInterpolations(node.Value); err != nil {
+			return
+		}
+
+		if node.Translate {
+			key, args := translationKey(node.Value)
+			e.add(key, "", node.SourcePosition)
+
+			for _, arg := range args {
+				e.expression(arg, node.SourcePosition)
+			}
+		} else if !node.Raw {
+			e.text(node.Value, node.SourcePosition)
+		}
+	case *parser.Tag:
+		for _, attr := range node.Attributes {
+			if attr.IsRaw {
+				e.text(attr.Value, attr.SourcePosition)
+			} else if len(attr.Value) > 0 {
+				e.expression(attr.Value, attr.SourcePosition)
+			}
+		}
+		e.visit(node.Block)
+	case *parser.Condition:
+		e.expression(node.Expression, node.SourcePosition)
+		e.visit(node.Positive)
+		e.visit(node.Negative)
+	case *parser.Each:
+		e.expression(node.Expression, node.SourcePosition)
+		e.visit(node.Block)
+	case *parser.Buffered:
+		e.expression(node.Expression, node.SourcePosition)
+	case *parser.Assignment:
+		e.expression(node.Expression, node.SourcePosition)
+	case *parser.Code:
+		e.expression(node.Expression, node.SourcePosition)
+	case *parser.Mixin:
+		e.visit(node.Block)
+	case *parser.MixinCall:
+		for _, arg := range node.Args {
+			e.expression(arg, node.SourcePosition)
+		}
+	}
+}
+
+func (e *extractor) file(filename string) error {
+	if err := e.compiler.ParseFile(filename); err != nil {
+		return err
+	}
+
+	e.visit(e.compiler.node)
+	return nil
+}
+
+func (e *extractor) dir(dirname string, dopt DirOptions) error {
+	opt := e.compiler.Options
+
+	dir, err := opt.Fs.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	files, err := dir.Readdir(0)
+	if err != nil {
+		return err
+	}
+
+	// Files are visited in a fixed order so that the output is stable
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	for _, file := range files {
+		filename := file.Name()
+		fullpath := path.Join(opt.PathSeparator, dirname, filename)
+
+		if dopt.Recursive && file.IsDir() {
+			if err := e.dir(fullpath, dopt); err != nil {
+				return err
+			}
+		} else if filepath.Ext(filepath.Clean(filename)) == dopt.Ext {
+			if err := e.file(fullpath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Extracts the translatable messages of a template file: texts written with the `~` shorthand and
+// calls of t and tn with string literal keys. Messages are returned in order of first appearance.
+func ExtractFile(filename string, options Options) ([]*Message, error) {
+	e := newExtractor(options)
+	if err := e.file(filename); err != nil {
+		return nil, err
+	}
+
+	return e.order, nil
+}
+
+// Extracts the translatable messages of all templates in a directory, see ExtractFile.
+func ExtractDir(dirname string, dopt DirOptions, options Options) ([]*Message, error) {
+	e := newExtractor(options)
+	if err := e.dir(dirname, dopt); err != nil {
+		return nil, err
+	}
+
+	return e.order, nil
+}
+
+// Writes messages as a gettext POT template.
+func WritePOT(w io.Writer, messages []*Message) error {
+	var b strings.Builder
+
+	b.WriteString("msgid \"\"\nmsgstr \"\"\n\"Content-Type: text/plain; charset=UTF-8\\n\"\n")
+
+	for _, msg := range messages {
+		b.WriteString("\n")
+
+		for _, pos := range msg.Positions {
+			fmt.Fprintf(&b, "#: %s:%d\n", pos.Filename, pos.LineNum)
+		}
+
+		b.WriteString("msgid " + quotePO(msg.Key) + "\n")
+		if len(msg.Plural) > 0 {
+			b.WriteString("msgid_plural " + quotePO(msg.Plural) + "\n")
+			b.WriteString("msgstr[0] \"\"\nmsgstr[1] \"\"\n")
+		} else {
+			b.WriteString("msgstr \"\"\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var poEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+
+// Quotes a PO string. Unlike Go strings, PO strings have no escapes for non-ASCII characters, which
+// are written as is.
+func quotePO(s string) string {
+	return `"` + poEscaper.Replace(s) + `"`
+}
+
+// Writes messages as a JSON list of objects with the key, plural key and positions of each message.
+func WriteJSON(w io.Writer, messages []*Message) error {
+	type jsonMessage struct {
+		Key       string   `json:"key"`
+		Plural    string   `json:"plural,omitempty"`
+		Positions []string `json:"positions"`
+	}
+
+	list := make([]jsonMessage, len(messages))
+	for i, msg := range messages {
+		list[i] = jsonMessage{Key: msg.Key, Plural: msg.Plural}
+		for _, pos := range msg.Positions {
+			list[i].Positions = append(list[i].Positions, fmt.Sprintf("%s:%d", pos.Filename, pos.LineNum))
+		}
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
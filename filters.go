@@ -0,0 +1,187 @@
+package jade
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter transforms the text of a filter block at compile time, see Options.Filters. The options
+// are those written in parentheses after the filter name, as in `:markdown(gfm="true")`.
+type Filter func(text string, options map[string]string) (string, error)
+
+// Filters available without being registered, filters in Options.Filters take precedence.
+var builtinFilters = map[string]Filter{
+	"cdata":    filterCDATA,
+	"css":      filterCSS,
+	"escape":   filterEscape,
+	"markdown": filterMarkdown,
+}
+
+func filterCDATA(text string, options map[string]string) (string, error) {
+	return "<![CDATA[" + strings.Replace(text, "]]>", "]]]]><![CDATA[>", -1) + "]]>", nil
+}
+
+func filterCSS(text string, options map[string]string) (string, error) {
+	return "<style>" + text + "</style>", nil
+}
+
+func filterEscape(text string, options map[string]string) (string, error) {
+	return html.EscapeString(text), nil
+}
+
+var (
+	rgxMarkdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	rgxMarkdownBullet  = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	rgxMarkdownNumber  = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+	rgxMarkdownRule    = regexp.MustCompile(`^(?:-\s*){3,}$|^(?:\*\s*){3,}$`)
+	rgxMarkdownCode    = regexp.MustCompile("`([^`]+)`")
+	rgxMarkdownLink    = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]*)\)`)
+	rgxMarkdownStrong  = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	rgxMarkdownEm      = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+// Renders a subset of markdown: headings, paragraphs, lists, block quotes, fenced code blocks,
+// horizontal rules, and inline code, links, strong and emphasized text. HTML within the text is
+// escaped.
+func filterMarkdown(text string, options map[string]string) (string, error) {
+	var out []string
+	var paragraph []string
+	var list string
+	var items []string
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			out = append(out, "<p>"+markdownInline(strings.Join(paragraph, "\n"))+"</p>")
+			paragraph = nil
+		}
+	}
+
+	flushList := func() {
+		if len(items) > 0 {
+			out = append(out, "<"+list+"><li>"+strings.Join(items, "</li><li>")+"</li></"+list+">")
+			items = nil
+		}
+	}
+
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+
+		if strings.HasPrefix(line, "```") {
+			flushParagraph()
+			flushList()
+
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+
+			// Only the indentation common to all lines is removed, relative indentation is kept
+			code = trimCommonIndent(code)
+			for j := range code {
+				code[j] = html.EscapeString(code[j])
+			}
+
+			out = append(out, "<pre><code>"+strings.Join(code, "\n")+"</code></pre>")
+			continue
+		}
+
+		if len(line) == 0 {
+			flushParagraph()
+			flushList()
+			continue
+		}
+
+		if sm := rgxMarkdownHeading.FindStringSubmatch(line); len(sm) != 0 {
+			flushParagraph()
+			flushList()
+			level := strconv.Itoa(len(sm[1]))
+			out = append(out, "<h"+level+">"+markdownInline(sm[2])+"</h"+level+">")
+		} else if rgxMarkdownRule.MatchString(line) {
+			flushParagraph()
+			flushList()
+			out = append(out, "<hr>")
+		} else if sm := rgxMarkdownBullet.FindStringSubmatch(line); len(sm) != 0 {
+			flushParagraph()
+			if list != "ul" {
+				flushList()
+			}
+			list = "ul"
+			items = append(items, markdownInline(sm[1]))
+		} else if sm := rgxMarkdownNumber.FindStringSubmatch(line); len(sm) != 0 {
+			flushParagraph()
+			if list != "ol" {
+				flushList()
+			}
+			list = "ol"
+			items = append(items, markdownInline(sm[1]))
+		} else if strings.HasPrefix(line, ">") {
+			flushParagraph()
+			flushList()
+			out = append(out, "<blockquote>"+markdownInline(strings.TrimSpace(line[1:]))+"</blockquote>")
+		} else if len(items) > 0 {
+			// Continuation of the last list item
+			items[len(items)-1] += "\n" + markdownInline(line)
+		} else {
+			paragraph = append(paragraph, line)
+		}
+	}
+
+	flushParagraph()
+	flushList()
+
+	return strings.Join(out, "\n"), nil
+}
+
+// Removes the leading whitespace shared by all non-blank lines.
+func trimCommonIndent(lines []string) []string {
+	indent := ""
+	found := false
+
+	for _, line := range lines {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if !found {
+			indent, found = lead, true
+			continue
+		}
+
+		for !strings.HasPrefix(lead, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = strings.TrimPrefix(line, indent)
+	}
+
+	return result
+}
+
+// Renders inline markdown, code spans are left unformatted.
+func markdownInline(text string) string {
+	var buf strings.Builder
+
+	format := func(text string) string {
+		text = html.EscapeString(text)
+		text = rgxMarkdownLink.ReplaceAllString(text, `<a href="$2">$1</a>`)
+		text = rgxMarkdownStrong.ReplaceAllString(text, `<strong>$1$2</strong>`)
+		return rgxMarkdownEm.ReplaceAllString(text, `<em>$1$2</em>`)
+	}
+
+	last := 0
+	for _, loc := range rgxMarkdownCode.FindAllStringSubmatchIndex(text, -1) {
+		buf.WriteString(format(text[last:loc[0]]))
+		buf.WriteString("<code>" + html.EscapeString(text[loc[2]:loc[3]]) + "</code>")
+		last = loc[1]
+	}
+	buf.WriteString(format(text[last:]))
+
+	return buf.String()
+}
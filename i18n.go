@@ -0,0 +1,819 @@
+package jade
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Catalog holds translated messages of any number of locales. Messages are looked up by their key,
+// which is the text of the message in the source language, like "Welcome back, {0}". Placeholders
+// {0}, {1}, ... are replaced with the arguments of the translation.
+type Catalog struct {
+	// Locale used when the template data does not specify one.
+	// Default: ""
+	DefaultLocale string
+	// Locale of the message keys. Its plural rules select between the singular and plural key of
+	// untranslated messages.
+	// Default: "en"
+	SourceLocale string
+
+	// Locale -> key -> plural category -> text. Messages without plural forms use "other".
+	messages map[string]map[string]map[string]string
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{SourceLocale: "en", messages: make(map[string]map[string]map[string]string)}
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
+
+// Adds the translation of a message.
+func (c *Catalog) Add(locale, key, translation string) {
+	c.AddPlural(locale, key, map[string]string{"other": translation})
+}
+
+// Adds a translation with plural forms keyed by CLDR plural category (zero, one, two, few, many, other).
+func (c *Catalog) AddPlural(locale, key string, forms map[string]string) {
+	locale = normalizeLocale(locale)
+
+	if c.messages[locale] == nil {
+		c.messages[locale] = make(map[string]map[string]string)
+	}
+
+	c.messages[locale][key] = forms
+}
+
+// Loads translations from a JSON object mapping keys to either a translation or an object of plural forms:
+//
+//	{"Welcome back, {0}": "Willkommen zurück, {0}", "{0} items": {"one": "{0} Artikel", "other": "{0} Artikel"}}
+func (c *Catalog) LoadJSON(locale string, r io.Reader) error {
+	var messages map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&messages); err != nil {
+		return err
+	}
+
+	for key, raw := range messages {
+		var translation string
+		if err := json.Unmarshal(raw, &translation); err == nil {
+			c.Add(locale, key, translation)
+			continue
+		}
+
+		var forms map[string]string
+		if err := json.Unmarshal(raw, &forms); err != nil {
+			return fmt.Errorf("invalid translation of %q: %s", key, err)
+		}
+
+		c.AddPlural(locale, key, forms)
+	}
+
+	return nil
+}
+
+var rgxPOLine = regexp.MustCompile(`^(msgctxt|msgid|msgid_plural|msgstr(?:\[(\d+)\])?)\s+(".*")$`)
+
+// Loads translations from a gettext PO file. Fuzzy entries are skipped, entries with a msgctxt are
+// stored under the gettext key context + "\x04" + msgid and do not replace entries without a context.
+// Plural translations msgstr[0], msgstr[1], ... are assigned to the CLDR plural categories of the
+// locale by evaluating the plural formula of the Plural-Forms header, a header whose forms do not
+// match the plural rules of the locale is an error. Without a header, the forms are assigned in CLDR
+// order (zero, one, two, few, many, other), Czech and Slovak files with three forms are read as one,
+// few and other.
+func (c *Catalog) LoadPO(locale string, r io.Reader) error {
+	var (
+		context, id, plural, field string
+		strs                       map[int]string
+		fuzzy                      bool
+		index, start               int
+		header                     *poPluralHeader
+	)
+
+	flush := func() error {
+		defer func() {
+			context, id, plural, field, strs, fuzzy = "", "", "", "", make(map[int]string), false
+		}()
+
+		if len(id) == 0 && len(context) == 0 && len(strs[0]) > 0 {
+			var err error
+			header, err = parsePOHeader(locale, strs[0])
+			return err
+		}
+
+		if len(id) == 0 || fuzzy || len(strs) == 0 {
+			return nil
+		}
+
+		key := id
+		if len(context) > 0 {
+			key = context + "\x04" + id
+		}
+
+		if len(plural) == 0 {
+			if len(strs[0]) > 0 {
+				c.Add(locale, key, strs[0])
+			}
+			return nil
+		}
+
+		var categories [][]string
+		if header != nil {
+			if len(strs) != header.nplurals {
+				return fmt.Errorf("msgid %q has %d plural forms, Plural-Forms specifies %d", id, len(strs), header.nplurals)
+			}
+			categories = header.categories
+		} else {
+			for _, category := range poPluralCategories(locale, len(strs)) {
+				categories = append(categories, []string{category})
+			}
+		}
+
+		forms := make(map[string]string)
+		for i := range strs {
+			if i >= len(categories) {
+				return fmt.Errorf("msgid %q has no plural form %d", id, i)
+			}
+
+			if len(strs[i]) > 0 {
+				for _, category := range categories[i] {
+					forms[category] = strs[i]
+				}
+			}
+		}
+		c.AddPlural(locale, key, forms)
+
+		return nil
+	}
+
+	flush()
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		// Comments and blank lines end the previous message
+		if len(text) == 0 || (strings.HasPrefix(text, "#") && field == "msgstr") {
+			if err := flush(); err != nil {
+				return fmt.Errorf("line %d: %s", start, err)
+			}
+		}
+
+		switch {
+		case len(text) == 0:
+		case strings.HasPrefix(text, "#,"):
+			fuzzy = fuzzy || strings.Contains(text, "fuzzy")
+		case strings.HasPrefix(text, "#"):
+		case strings.HasPrefix(text, `"`):
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid string %s", line, text)
+			}
+
+			switch field {
+			case "msgctxt":
+				context += value
+			case "msgid":
+				id += value
+			case "msgid_plural":
+				plural += value
+			case "msgstr":
+				strs[index] += value
+			}
+		default:
+			sm := rgxPOLine.FindStringSubmatch(text)
+			if sm == nil {
+				return fmt.Errorf("line %d: unexpected %s", line, text)
+			}
+
+			value, err := strconv.Unquote(sm[3])
+			if err != nil {
+				return fmt.Errorf("line %d: invalid string %s", line, sm[3])
+			}
+
+			// A new message starts without a blank line in between
+			if (sm[1] == "msgid" || sm[1] == "msgctxt") && field == "msgstr" {
+				if err := flush(); err != nil {
+					return fmt.Errorf("line %d: %s", start, err)
+				}
+			}
+
+			if len(field) == 0 {
+				start = line
+			}
+
+			field, index = sm[1], 0
+			switch {
+			case sm[1] == "msgctxt":
+				context = value
+			case sm[1] == "msgid":
+				id = value
+			case sm[1] == "msgid_plural":
+				plural = value
+			case strings.HasPrefix(sm[1], "msgstr"):
+				field = "msgstr"
+				if len(sm[2]) > 0 {
+					index, _ = strconv.Atoi(sm[2])
+				}
+				strs[index] = value
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
+		return fmt.Errorf("line %d: %s", start, err)
+	}
+
+	return nil
+}
+
+// Loads a .json or .po file.
+func (c *Catalog) LoadFile(locale, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		err = c.LoadJSON(locale, file)
+	case ".po":
+		err = c.LoadPO(locale, file)
+	default:
+		return fmt.Errorf("unsupported catalog format: %s", filename)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return nil
+}
+
+// Finds the translation of a message, falling back from a regional locale to its language.
+func (c *Catalog) lookup(locale, key string) map[string]string {
+	if c == nil {
+		return nil
+	}
+
+	locale = normalizeLocale(locale)
+
+	for len(locale) > 0 {
+		if forms, ok := c.messages[locale][key]; ok {
+			return forms
+		}
+
+		i := strings.LastIndexByte(locale, '-')
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+
+	return nil
+}
+
+func (c *Catalog) sourceLocale() string {
+	if c == nil || len(c.SourceLocale) == 0 {
+		return "en"
+	}
+
+	return c.SourceLocale
+}
+
+// Translates a message. If the translation has plural forms, the first argument selects the form.
+func (c *Catalog) Translate(locale, key string, args ...interface{}) string {
+	forms := c.lookup(locale, key)
+
+	if len(forms) == 0 {
+		return formatMessage(key, args)
+	}
+
+	text, ok := forms["other"]
+	if len(args) > 0 {
+		if n, isNumber := toNumber(args[0]); isNumber {
+			if form, found := forms[pluralCategory(locale, n)]; found {
+				text, ok = form, true
+			}
+		}
+	}
+
+	if !ok {
+		return formatMessage(key, args)
+	}
+
+	return formatMessage(text, args)
+}
+
+// Translates a message with a plural form selected by count, which is available as {0}. The singular
+// key identifies the message, untranslated messages use the singular or plural key.
+func (c *Catalog) TranslatePlural(locale, singular, plural string, count interface{}, args ...interface{}) string {
+	args = append([]interface{}{count}, args...)
+	n, isNumber := toNumber(count)
+
+	if forms := c.lookup(locale, singular); len(forms) > 0 {
+		text, ok := forms["other"]
+		if isNumber {
+			if form, found := forms[pluralCategory(locale, n)]; found {
+				text, ok = form, true
+			}
+		}
+
+		if ok {
+			return formatMessage(text, args)
+		}
+	}
+
+	if isNumber && pluralCategory(c.sourceLocale(), n) == "one" {
+		return formatMessage(singular, args)
+	}
+
+	return formatMessage(plural, args)
+}
+
+var rgxPlaceholder = regexp.MustCompile(`\{(\d+)\}`)
+
+// Replaces the placeholders {0}, {1}, ... of a message with its arguments.
+func formatMessage(text string, args []interface{}) string {
+	return rgxPlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+		index, _ := strconv.Atoi(placeholder[1 : len(placeholder)-1])
+		if index >= len(args) {
+			return placeholder
+		}
+
+		return runtime_string(args[index])
+	})
+}
+
+// Operands of CLDR plural rules: the integer digits i and the number of visible fraction digits v.
+func pluralOperands(n number) (uint64, int) {
+	str := strings.TrimPrefix(n.String(), "-")
+
+	integer, fraction := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		integer, fraction = str[:i], str[i+1:]
+	}
+
+	i, err := strconv.ParseUint(integer, 10, 64)
+	if err != nil {
+		// Only the last digits matter for plural rules
+		i, _ = strconv.ParseUint(integer[len(integer)-6:], 10, 64)
+		i += 1000000
+	}
+
+	return i, len(fraction)
+}
+
+// Plural rules of a language, returning the CLDR plural category of a number.
+type pluralRule func(i uint64, v int) string
+
+var (
+	pluralOneRule = func(i uint64, v int) string {
+		if i == 1 && v == 0 {
+			return "one"
+		}
+		return "other"
+	}
+	pluralZeroOneRule = func(i uint64, v int) string {
+		if i <= 1 {
+			return "one"
+		}
+		return "other"
+	}
+	pluralSlavicRule = func(i uint64, v int) string {
+		switch {
+		case v != 0:
+			return "other"
+		case i%10 == 1 && i%100 != 11:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		}
+		return "many"
+	}
+	pluralPolishRule = func(i uint64, v int) string {
+		switch {
+		case v != 0:
+			return "other"
+		case i == 1:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		}
+		return "many"
+	}
+	pluralCzechRule = func(i uint64, v int) string {
+		switch {
+		case v != 0:
+			return "many"
+		case i == 1:
+			return "one"
+		case i >= 2 && i <= 4:
+			return "few"
+		}
+		return "other"
+	}
+	pluralArabicRule = func(i uint64, v int) string {
+		switch {
+		case v != 0:
+			return "other"
+		case i == 0:
+			return "zero"
+		case i == 1:
+			return "one"
+		case i == 2:
+			return "two"
+		case i%100 >= 3 && i%100 <= 10:
+			return "few"
+		case i%100 >= 11:
+			return "many"
+		}
+		return "other"
+	}
+	pluralHebrewRule = func(i uint64, v int) string {
+		switch {
+		case v != 0:
+			return "other"
+		case i == 1:
+			return "one"
+		case i == 2:
+			return "two"
+		}
+		return "other"
+	}
+	pluralNoneRule = func(i uint64, v int) string {
+		return "other"
+	}
+)
+
+var pluralRules = map[string]pluralRule{
+	"fr": pluralZeroOneRule, "pt": pluralZeroOneRule, "hi": pluralZeroOneRule,
+	"ru": pluralSlavicRule, "uk": pluralSlavicRule, "be": pluralSlavicRule,
+	"pl": pluralPolishRule,
+	"cs": pluralCzechRule, "sk": pluralCzechRule,
+	"ar": pluralArabicRule,
+	"he": pluralHebrewRule,
+	"ja": pluralNoneRule, "zh": pluralNoneRule, "ko": pluralNoneRule, "vi": pluralNoneRule,
+	"th": pluralNoneRule, "id": pluralNoneRule, "ms": pluralNoneRule,
+}
+
+// Categories used by each plural rule in CLDR order.
+var pluralRuleCategories = map[string][]string{
+	"fr": {"one", "other"}, "pt": {"one", "other"}, "hi": {"one", "other"},
+	"ru": {"one", "few", "many"}, "uk": {"one", "few", "many"}, "be": {"one", "few", "many"},
+	"pl": {"one", "few", "many"},
+	"cs": {"one", "few", "many", "other"}, "sk": {"one", "few", "many", "other"},
+	"ar": {"zero", "one", "two", "few", "many", "other"},
+	"he": {"one", "two", "other"},
+	"ja": {"other"}, "zh": {"other"}, "ko": {"other"}, "vi": {"other"},
+	"th": {"other"}, "id": {"other"}, "ms": {"other"},
+}
+
+// Categories of gettext plural forms for languages whose usual Plural-Forms have fewer forms than the
+// CLDR rule, by number of forms. Numbers of other categories fall back to "other".
+var poPluralForms = map[string]map[int][]string{
+	"cs": {3: {"one", "few", "other"}},
+	"sk": {3: {"one", "few", "other"}},
+}
+
+// Returns the plural categories of the msgstr entries of a PO file.
+func poPluralCategories(locale string, forms int) []string {
+	if categories, ok := poPluralForms[pluralLanguage(locale)][forms]; ok {
+		return categories
+	}
+
+	return pluralCategories(locale)
+}
+
+func pluralLanguage(locale string) string {
+	locale = normalizeLocale(locale)
+	if i := strings.IndexByte(locale, '-'); i >= 0 {
+		locale = locale[:i]
+	}
+
+	return locale
+}
+
+// Returns the CLDR plural category of n in locale. Languages without specific rules use the English rule.
+func pluralCategory(locale string, n number) string {
+	i, v := pluralOperands(n)
+
+	if rule, ok := pluralRules[pluralLanguage(locale)]; ok {
+		return rule(i, v)
+	}
+
+	return pluralOneRule(i, v)
+}
+
+// Returns the plural categories used by locale.
+func pluralCategories(locale string) []string {
+	if categories, ok := pluralRuleCategories[pluralLanguage(locale)]; ok {
+		return categories
+	}
+
+	return []string{"one", "other"}
+}
+
+// Data passed to a template along with the locale to render it in.
+type localizedData struct {
+	locale string
+	data   interface{}
+	// Number of dynamic includes the data was passed through
+	depth int
+}
+
+// Wraps template data to render a template in the given locale:
+//
+//	tpl.Execute(w, jade.WithLocale(data, "de-AT"))
+//
+// Alternatively the data can provide a `Locale() string` method.
+func WithLocale(data interface{}, locale string) interface{} {
+	return &localizedData{locale, data, 0}
+}
+
+// Translation helpers bound to the catalog of the compiler options.
+type translator struct {
+	catalog *Catalog
+}
+
+func (tr translator) funcs() template.FuncMap {
+	return template.FuncMap{
+		"__jade_t":      tr.translate,
+		"__jade_tn":     tr.translatePlural,
+		"__jade_locale": tr.locale,
+	}
+}
+
+func (tr translator) locale(data interface{}) string {
+	switch v := data.(type) {
+	case *localizedData:
+		if len(v.locale) > 0 {
+			return v.locale
+		}
+	case interface{ Locale() string }:
+		if locale := v.Locale(); len(locale) > 0 {
+			return locale
+		}
+	}
+
+	if tr.catalog != nil {
+		return tr.catalog.DefaultLocale
+	}
+
+	return ""
+}
+
+func (tr translator) translate(locale, key string, args ...interface{}) string {
+	return tr.catalog.Translate(locale, key, args...)
+}
+
+func (tr translator) translatePlural(locale, singular, plural string, count interface{}, args ...interface{}) string {
+	return tr.catalog.TranslatePlural(locale, singular, plural, count, args...)
+}
+
+// Unwraps data passed with WithLocale. The result is a single item list so that the template body can
+// be executed with the unwrapped data as dot, even if it is nil.
+func runtime_scope(data interface{}) []interface{} {
+	if localized, ok := data.(*localizedData); ok {
+		return []interface{}{localized.data}
+	}
+
+	return []interface{}{data}
+}
+
+// Converts the text of a translatable text block into a message key and the expressions of its
+// interpolations: "Hello #{Name}" becomes "Hello {0}" and ["Name"].
+func translationKey(text string) (string, []string) {
+	var key strings.Builder
+	var args []string
+
+	segments, err := splitInterpolations(text)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	for _, segment := range segments {
+		if segment.expression {
+			key.WriteString("{" + strconv.Itoa(len(args)) + "}")
+			args = append(args, segment.value)
+		} else {
+			key.WriteString(segment.value)
+		}
+	}
+
+	return strings.TrimSpace(key.String()), args
+}
+
+// Plural forms of a PO file, the msgstr index -> CLDR plural categories of the locale.
+type poPluralHeader struct {
+	nplurals   int
+	categories [][]string
+}
+
+var rgxPOPluralForms = regexp.MustCompile(`(?m)^Plural-Forms:\s*nplurals\s*=\s*(\d+)\s*;\s*plural\s*=([^;\n]+);?`)
+
+// Reads the Plural-Forms header of a PO file and assigns the plural categories of locale to its forms
+// by evaluating the formula for the integers up to 1000, the range of the modulos of common formulas.
+func parsePOHeader(locale, header string) (*poPluralHeader, error) {
+	sm := rgxPOPluralForms.FindStringSubmatch(header)
+	if sm == nil {
+		return nil, nil
+	}
+
+	nplurals, _ := strconv.Atoi(sm[1])
+	formula, err := parsePluralFormula(sm[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Plural-Forms: %s", err)
+	}
+
+	result := &poPluralHeader{nplurals, make([][]string, nplurals)}
+	forms := make(map[string]uint64)
+
+	for n := uint64(0); n <= 1000; n++ {
+		form := formula(n)
+		if form >= uint64(nplurals) {
+			return nil, fmt.Errorf("Plural-Forms selects form %d of %d for %d", form, nplurals, n)
+		}
+
+		value, _ := toNumber(n)
+		category := pluralCategory(locale, value)
+		if other, ok := forms[category]; !ok {
+			forms[category] = form
+			result.categories[form] = append(result.categories[form], category)
+		} else if other != form {
+			return nil, fmt.Errorf("Plural-Forms do not match the plural rules of %s: forms %d and %d are both %q", locale, other, form, category)
+		}
+	}
+
+	return result, nil
+}
+
+// Parses the plural formula of a Plural-Forms header, a C expression of n.
+func parsePluralFormula(expr string) (func(n uint64) uint64, error) {
+	p := &pluralFormulaParser{expr: expr}
+	formula := p.parseTernary()
+
+	if p.skipSpace(); p.err == nil && p.pos < len(p.expr) {
+		p.err = fmt.Errorf("unexpected %q", p.expr[p.pos:])
+	}
+
+	return formula, p.err
+}
+
+type pluralFormulaParser struct {
+	expr string
+	pos  int
+	err  error
+}
+
+func (p *pluralFormulaParser) skipSpace() {
+	for p.pos < len(p.expr) && strings.IndexByte(" \t", p.expr[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// Consumes the first of the operators at the current position.
+func (p *pluralFormulaParser) operator(operators ...string) string {
+	p.skipSpace()
+	for _, op := range operators {
+		if strings.HasPrefix(p.expr[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+
+	return ""
+}
+
+func boolToUint(b bool) uint64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (p *pluralFormulaParser) parseTernary() func(uint64) uint64 {
+	cond := p.parseBinary(0)
+	if len(p.operator("?")) == 0 {
+		return cond
+	}
+
+	yes := p.parseTernary()
+	if len(p.operator(":")) == 0 && p.err == nil {
+		p.err = fmt.Errorf("missing : in %q", p.expr)
+	}
+	no := p.parseTernary()
+
+	return func(n uint64) uint64 {
+		if cond(n) != 0 {
+			return yes(n)
+		}
+		return no(n)
+	}
+}
+
+// Binary operators by increasing precedence, longer operators first.
+var pluralFormulaOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *pluralFormulaParser) parseBinary(level int) func(uint64) uint64 {
+	if level == len(pluralFormulaOperators) {
+		return p.parseUnary()
+	}
+
+	left := p.parseBinary(level + 1)
+	for {
+		op := p.operator(pluralFormulaOperators[level]...)
+		if len(op) == 0 {
+			return left
+		}
+
+		l, r := left, p.parseBinary(level+1)
+		left = func(n uint64) uint64 {
+			a, b := l(n), r(n)
+			switch op {
+			case "||":
+				return boolToUint(a != 0 || b != 0)
+			case "&&":
+				return boolToUint(a != 0 && b != 0)
+			case "==":
+				return boolToUint(a == b)
+			case "!=":
+				return boolToUint(a != b)
+			case "<=":
+				return boolToUint(a <= b)
+			case ">=":
+				return boolToUint(a >= b)
+			case "<":
+				return boolToUint(a < b)
+			case ">":
+				return boolToUint(a > b)
+			case "+":
+				return a + b
+			case "-":
+				return a - b
+			case "*":
+				return a * b
+			}
+
+			if b == 0 {
+				return 0
+			}
+			if op == "/" {
+				return a / b
+			}
+			return a % b
+		}
+	}
+}
+
+func (p *pluralFormulaParser) parseUnary() func(uint64) uint64 {
+	if len(p.operator("!")) > 0 {
+		operand := p.parseUnary()
+		return func(n uint64) uint64 { return boolToUint(operand(n) == 0) }
+	}
+
+	if len(p.operator("(")) > 0 {
+		inner := p.parseTernary()
+		if len(p.operator(")")) == 0 && p.err == nil {
+			p.err = fmt.Errorf("missing ) in %q", p.expr)
+		}
+		return inner
+	}
+
+	if len(p.operator("n")) > 0 {
+		return func(n uint64) uint64 { return n }
+	}
+
+	start := p.pos
+	for p.pos < len(p.expr) && p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9' {
+		p.pos++
+	}
+
+	value, err := strconv.ParseUint(p.expr[start:p.pos], 10, 64)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("unexpected %q", p.expr[start:])
+	}
+
+	return func(uint64) uint64 { return value }
+}
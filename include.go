@@ -0,0 +1,74 @@
+package jade
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strings"
+)
+
+// Renders templates chosen at execution time with `include #{expression}`.
+type includer struct {
+	templates map[string]*template.Template
+}
+
+func (in includer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"__jade_include": in.include,
+	}
+}
+
+// Includes nested deeper than this fail, like {{template}} calls nested too deep in Go templates.
+const maxIncludeDepth = 1000
+
+type includeDepthError struct {
+	name string
+}
+
+func (e *includeDepthError) Error() string {
+	return fmt.Sprintf("exceeded maximum include depth (%d) including %s", maxIncludeDepth, e.name)
+}
+
+// Executes the template with the given name, as used as key by CompileDir, with the data of the
+// including template. The extension of the name is optional. The data is passed along with the locale
+// of the including template and the include depth, taken from the root data of the including template.
+// Lines of the output after the first are prefixed with indent.
+func (in includer) include(name, indent, locale string, root, data interface{}) (template.HTML, error) {
+	tmpl, ok := in.templates[name]
+	if !ok {
+		tmpl, ok = in.templates[strings.TrimSuffix(name, filepath.Ext(name))]
+	}
+
+	if !ok {
+		return "", fmt.Errorf("unknown template: %s", name)
+	}
+
+	depth := 1
+	if localized, ok := root.(*localizedData); ok {
+		depth = localized.depth + 1
+	}
+
+	if depth > maxIncludeDepth {
+		return "", &includeDepthError{name}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, &localizedData{locale, data, depth}); err != nil {
+		// Report the innermost include instead of the error of every level
+		var depthErr *includeDepthError
+		if errors.As(err, &depthErr) {
+			return "", depthErr
+		}
+
+		return "", err
+	}
+
+	output := strings.TrimSuffix(buf.String(), "\n")
+	if len(indent) > 0 {
+		output = strings.Replace(output, "\n", "\n"+indent, -1)
+	}
+
+	return template.HTML(output), nil
+}
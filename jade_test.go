@@ -2,14 +2,19 @@ package jade
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	//"html/template"
 	//"os"
 	"strings"
 	"testing"
+	"time"
     "io/ioutil"
     "flag"
     "html/template"
+    "github.com/go-floki/jade/parser"
 )
 
 var (
@@ -228,12 +233,12 @@ func Test_JadeAttribute2(t *testing.T) {
 }
 
 func Test_JadeAttributeEscaping(t *testing.T) {
-	res, err := run(`p(style=$)`, "<div>")
+	res, err := run(`p(title=$)`, "<div>")
 
 	if err != nil {
 		t.Fatal(err.Error())
 	} else {
-		expect(res, `<p style="&lt;div&gt;"></p>`, t)
+		expect(res, `<p title="&lt;div&gt;"></p>`, t)
 	}
 }
 
@@ -401,6 +406,1158 @@ func Test_FuncCall(t *testing.T) {
 	}
 }
 
+func Test_EachLoopMetadata(t *testing.T) {
+	res, err := run(`
+		each $item in Items
+			span
+				[class="first"] ? $loop.First
+				| #{$loop.Index}/#{$loop.Length} #{$item}
+			unless $loop.Last
+				| ,`, map[string][]string{"Items": {"a", "b", "c"}})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<span class="first">0/3 a</span>,<span>1/3 b</span>,<span>2/3 c</span>`, t)
+	}
+}
+
+func Test_EachLoopParent(t *testing.T) {
+	res, err := run(`
+		each $row in Rows
+			each $cell in $row
+				i #{$loop.Parent.Index}#{$loop.Index}`, map[string][][]int{"Rows": {{1, 2}, {3}}})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<i>00</i><i>01</i><i>10</i>`, t)
+	}
+}
+
+func Test_EachWithoutLoopMetadata(t *testing.T) {
+	cmp := New()
+	if err := cmp.Parse("each $x in Items\n\tp #{$x}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	src, err := cmp.CompileString()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if strings.Contains(src, "__jade_loop") {
+		t.Fatalf("Unexpected loop state in compiled template: %s", src)
+	}
+}
+
+type testCounter struct {
+	N int
+}
+
+func (c *testCounter) Inc() int {
+	c.N++
+	return c.N
+}
+
+func Test_UnbufferedCode(t *testing.T) {
+	res, err := run(`
+		- var $total = 0
+		- $count := 0
+		each $n in Numbers
+			- $total = $total + $n
+			- $count = $count + 1
+		p #{$count}: #{$total}`, map[string][]int{"Numbers": {1, 2, 3}})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>3: 6</p>`, t)
+	}
+
+	counter := &testCounter{}
+	res, err = run(`
+		- Counter.Inc()
+		- Counter.Inc()
+		p #{Counter.N}`, map[string]*testCounter{"Counter": counter})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>2</p>`, t)
+	}
+	// Unbuffered lines starting with a variable are evaluated as well
+	counter = &testCounter{}
+	res, err = run(`
+		- $c := Counter
+		- $c.Inc()
+		- $c
+		p #{$c.N}`, map[string]*testCounter{"Counter": counter})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>1</p>`, t)
+	}
+}
+
+func Test_ReassignmentInScopes(t *testing.T) {
+	res, err := run(`
+		$total = 0
+		each $n in Numbers
+			if $n > 1
+				$total = $total + $n
+				- var $shadow = 100
+		$shadow = 1
+		p #{$total} #{$shadow}`, map[string][]int{"Numbers": {1, 2, 3}})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>5 1</p>`, t)
+	}
+
+	_, err = run(`
+		if true
+			- var $x = 1
+		- $x = 2`, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "undeclared variable $x") {
+		t.Fatalf("Expected undeclared variable error, got %v", err)
+	}
+}
+
+type testUser struct {
+	Name  string
+	Roles []string
+}
+
+func (u testUser) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u testUser) Avatar(size int) string {
+	return fmt.Sprintf("/avatars/%s-%d.png", u.Name, size)
+}
+
+func (u testUser) Join(a, b string) string {
+	return a + "-" + b
+}
+
+type testCart struct {
+	items []string
+}
+
+func (c testCart) Items() testCart {
+	return c
+}
+
+func (c testCart) Count() int {
+	return len(c.items)
+}
+
+func Test_IndexExpression(t *testing.T) {
+	data := map[string]interface{}{
+		"Items":        []string{"a", "b", "c", "d"},
+		"Map":          map[string]int{"key": 5},
+		"Users":        []testUser{{Name: "Ann"}, {Name: "Bob"}},
+		"Translations": map[string]string{"de": "Hallo", "en": "Hello"},
+		"Lang":         "de",
+	}
+
+	res, err := run(`
+		$lang = Lang
+		p #{Items[0]} #{Map["key"]} #{Users[1].Name} #{Translations[$lang]}`, data)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>a 5 Bob Hallo</p>`, t)
+	}
+}
+
+func Test_SliceExpression(t *testing.T) {
+	res, err := run(`
+		each $x in Items[1:3]
+			i #{$x}
+		each $x in Items[:1]
+			b #{$x}
+		p #{len(Items[2:])}`, map[string][]string{"Items": {"a", "b", "c", "d"}})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<i>b</i><i>c</i><b>a</b><p>2</p>`, t)
+	}
+}
+
+func Test_ConditionalExpression(t *testing.T) {
+	res, err := run(`
+		a(class=Active ? "on" : "off") #{Count > 1 ? "many" : Count == 1 ? "one" : "none"}
+		p #{User != nil ? User.Name : 'guest'}`, map[string]interface{}{"Active": true, "Count": 1, "User": (*testUser)(nil)})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<a class="on">one</a><p>guest</p>`, t)
+	}
+}
+
+func Test_CoalesceExpression(t *testing.T) {
+	res, err := run(`
+		p #{Nickname ?? Name}
+		p #{Missing ?? "-"}
+		p #{Zero ?? 1}`, map[string]interface{}{"Nickname": nil, "Name": "Ann", "Missing": []string(nil), "Zero": 0})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>Ann</p><p>-</p><p>0</p>`, t)
+	}
+}
+
+func Test_ShortCircuitExpression(t *testing.T) {
+	data := map[string]interface{}{"User": (*testUser)(nil), "Admin": &testUser{Name: "root"}}
+
+	res, err := run(`
+		if User != nil && User.Name == "x"
+			p user
+		if Admin != nil && Admin.Name == "root"
+			p admin
+		p #{User == nil || User.Name == ""} #{false || Admin.Name}`, data)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>admin</p><p>true root</p>`, t)
+	}
+}
+
+func Test_PipeWithArguments(t *testing.T) {
+	res, err := run(`
+		p #{Price | printf "%.2f EUR"}
+		p #{Name |> printf("%s!") | upper}
+		p #{Name |> len}`, map[string]interface{}{"Price": 3.5, "Name": "ann"})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>3.50 EUR</p><p>ANN!</p><p>3</p>`, t)
+	}
+}
+
+func Test_BitwiseExpression(t *testing.T) {
+	res, err := run(`
+		if Flags & 4 != 0
+			p writable
+		p #{Flags | 1} #{Flags ^ 2} #{Flags &^ 4} #{1 << 3} #{Flags >> 1} #{^0}`, map[string]uint8{"Flags": 6})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>writable</p><p>7 4 2 8 3 -1</p>`, t)
+	}
+}
+
+func Test_PipeOrBitwiseOr(t *testing.T) {
+	// A single | followed by the bare name of a helper is the bitwise or operator, pipes into helpers
+	// without arguments are written with |>
+	res, err := run(`
+		p #{Flags | first} #{Items |> first} #{Items |> first |> len}
+		p #{Items | sortBy "n" |> last} #{Name | upper}`, map[string]interface{}{
+		"Flags": 1,
+		"first": 2,
+		"Items": []map[string]int{{"n": 3}, {"n": 1}},
+		"Name":  "ann",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>3 map[n:3] 1</p><p>map[n:3] ANN</p>`, t)
+	}
+}
+
+func Test_CompositeLiterals(t *testing.T) {
+	res, err := run(`
+		mixin button($label, $opts)
+			button(class="btn-" + $opts.size, title=$opts["title"]) #{$label}
+
+		each $n in ["a", 'b', Name]
+			i #{$n}
+		+button("Save", {size: "lg", "title": Count > 1 ? "many" : "one"})
+		- $empty := len({})
+		p #{len([])} #{$empty} #{[1, 2, 3][1]}`, map[string]interface{}{"Name": "c", "Count": 2})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<i>a</i><i>b</i><i>c</i><button class="btn-lg" title="many">Save</button><p>0 0 2</p>`, t)
+	}
+}
+
+func Test_MethodCalls(t *testing.T) {
+	data := map[string]interface{}{
+		"User": testUser{Name: "ann", Roles: []string{"admin"}},
+		"Cart": testCart{items: []string{"a", "b"}},
+		"Sep":  "x",
+	}
+
+	res, err := run(`
+		$u = User
+		if User.HasRole("admin")
+			p admin
+		p #{Cart.Items().Count()} #{$u.Avatar(64)}
+		p #{User.Join(upper(Sep), $u.Join("a", "b"))} #{User.Join($u.Name, User.Join("c", Sep))}`, data)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>admin</p><p>2 /avatars/ann-64.png</p><p>X-a-b ann-c-x</p>`, t)
+	}
+}
+
+type testPercent int
+
+func Test_NumericOperands(t *testing.T) {
+	count := 4
+	res, err := run(`
+		p #{Small + Small} #{Unsigned - 2} #{Json * 2} #{Big + 1} #{Percent + Percent} #{Count * 2} #{Ratio / 2}
+		if Unsigned > Json
+			p larger
+		p #{User.Avatar(32 * 2)}`, map[string]interface{}{
+		"Small":    int8(20),
+		"Unsigned": uint(10),
+		"Json":     json.Number("7"),
+		"Big":      new(big.Int).Lsh(big.NewInt(1), 70),
+		"Percent":  testPercent(25),
+		"Count":    &count,
+		"Ratio":    float32(1.5),
+		"User":     testUser{Name: "ann"},
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>40 8 14 1180591620717411303425 50 8 0.75</p><p>larger</p><p>/avatars/ann-64.png</p>`, t)
+	}
+}
+
+func Test_NumericErrors(t *testing.T) {
+	if _, err := run(`p #{Small + Small}`, map[string]int8{"Small": 100}); err == nil || !strings.Contains(err.Error(), "integer overflow: int8 + int8") {
+		t.Fatalf("Expected overflow error, got %v", err)
+	}
+
+	if _, err := run(`p #{Count / Zero}`, map[string]int{"Count": 1, "Zero": 0}); err == nil || !strings.Contains(err.Error(), "division by zero: int / int") {
+		t.Fatalf("Expected division by zero error, got %v", err)
+	}
+}
+
+func Test_InvalidOperationErrors(t *testing.T) {
+	_, err := run(`p #{User + 1}`, map[string]interface{}{"User": testUser{Name: "ann"}})
+	if err == nil || !strings.Contains(err.Error(), "invalid operation: jade.testUser + int") {
+		t.Fatalf("Expected invalid operation error, got %v", err)
+	}
+
+	_, err = run(`if Name < 3
+	p less`, map[string]interface{}{"Name": []string{"ann"}})
+	if err == nil || !strings.Contains(err.Error(), "invalid operation: []string < int") {
+		t.Fatalf("Expected invalid operation error, got %v", err)
+	}
+}
+
+func Test_LenientOperations(t *testing.T) {
+	tmpl, err := Compile(`p #{User + 1}|#{Count / 0}|#{-User}
+if User > 1
+	p greater`, Options{Lenient: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]interface{}{"User": testUser{}, "Count": 3}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), `<p>||</p>`, t)
+}
+
+type testStatus string
+
+type testVersion struct {
+	Major, Minor int
+}
+
+func (v testVersion) Compare(o testVersion) int {
+	if v.Major != o.Major {
+		return v.Major - o.Major
+	}
+	return v.Minor - o.Minor
+}
+
+func Test_DeepEquality(t *testing.T) {
+	paid := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	res, err := run(`
+		if Status == Paid
+			p status
+		if Version == Required
+			p version
+		if Tags == Expected
+			p tags
+		if PaidAt == Local
+			p time
+		if Version != Newer
+			p differs`, map[string]interface{}{
+		"Status":   testStatus("paid"),
+		"Paid":     "paid",
+		"Version":  testVersion{1, 2},
+		"Required": testVersion{1, 2},
+		"Newer":    testVersion{1, 3},
+		"Tags":     []string{"a", "b"},
+		"Expected": []string{"a", "b"},
+		"PaidAt":   paid,
+		"Local":    paid.In(time.FixedZone("CEST", 2*60*60)),
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>status</p><p>version</p><p>tags</p><p>time</p><p>differs</p>`, t)
+	}
+}
+
+func Test_MethodOrdering(t *testing.T) {
+	now := time.Now()
+
+	res, err := run(`
+		if Deadline < Now
+			p overdue
+		if Version < Newer
+			p outdated
+		if Newer >= Version
+			p upgrade`, map[string]interface{}{
+		"Deadline": now.Add(-time.Hour),
+		"Now":      now,
+		"Version":  testVersion{1, 2},
+		"Newer":    testVersion{2, 0},
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>overdue</p><p>outdated</p><p>upgrade</p>`, t)
+	}
+}
+
+func Test_FloatFormatting(t *testing.T) {
+	res, err := run(`
+		p #{"Price: " + Price}
+		if Price == "3.5"
+			p equal
+		p #{Ratio + ""}`, map[string]interface{}{"Price": 3.5, "Ratio": float32(0.1)})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>Price: 3.5</p><p>equal</p><p>0.1</p>`, t)
+	}
+}
+
+func Test_NumberHelpers(t *testing.T) {
+	res, err := run(`
+		p #{number(Total)} #{Total | number 2 "de"} #{number("fr", -Small)}
+		p #{Price | fixed 2} #{fixed(1, Count)} #{number(2, "1234.5")}`, map[string]interface{}{
+		"Total": 1234567.891,
+		"Small": 1234,
+		"Price": 3.14159,
+		"Count": 3,
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<p>1,234,567.891 1.234.567,89 -1\u202f234</p><p>3.14 3.0 1,234.50</p>", t)
+	}
+}
+
+func Test_StringHelpers(t *testing.T) {
+	res, err := run(`
+		p #{trim(Padded)}|#{Path | trim "/"}|#{replace("-", " ", Slug)}|#{join(", ", split(",", Csv))}
+		if contains("b", Tags) && startsWith("He", Greeting) && (Greeting | endsWith "!")
+			p found
+		if contains("b", Tags) && (Greeting | endsWith "?")
+			p wrong
+		p #{endsWith("ßig!", Greeting)} #{endsWith("ßig", Greeting)}
+		p #{Greeting | truncate 8}|#{truncate(8, "...", Greeting)}|#{truncate(20, Greeting)}
+		p #{title("hello wide world")}|#{slugify(Greeting)}|#{pad(4, "0", 7)}|#{pad(-3, "a")}|#{repeat(3, "ab")}
+		p #{nl2br(Address)}`, map[string]interface{}{
+		"Padded":   "  x  ",
+		"Path":     "/docs/",
+		"Slug":     "a-b-c",
+		"Csv":      "a,b",
+		"Tags":     []string{"a", "b"},
+		"Greeting": "Hello, Wörld ßig!",
+		"Address":  "Main St <1>\nSpringfield",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>x|docs|a b c|a, b</p><p>found</p><p>true false</p><p>Hello,…|Hello...|Hello, Wörld ßig!</p><p>Hello Wide World|hello-world-ssig|0007|a  |ababab</p><p>Main St &lt;1&gt;<br>Springfield</p>`, t)
+	}
+}
+
+func Test_DateHelpers(t *testing.T) {
+	created := time.Date(2020, 5, 1, 22, 30, 0, 0, time.UTC)
+	now := time.Now()
+
+	res, err := run(`
+		p #{date(Created)}|#{Created | date "Jan 2, 2006"}|#{date("%d.%m.%Y %H:%M", Created)}|#{isoDate(Created)}|#{Stamp | date "2006"}
+		p #{timeAgo(Hours)}|#{relative(Days)}|#{timeAgo(Now)}`, map[string]interface{}{
+		"Created": created,
+		"Stamp":   created.Unix(),
+		"Hours":   now.Add(-3 * time.Hour),
+		"Days":    now.Add(49 * time.Hour),
+		"Now":     now,
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>2020-05-01|May 1, 2020|01.05.2020 22:30|2020-05-01T22:30:00Z|2020</p><p>3 hours ago|in 2 days|just now</p>`, t)
+	}
+}
+
+func Test_DateOptions(t *testing.T) {
+	tmpl, err := Compile(`p #{date(Created)} #{isoDate("2020-05-01T22:30:00Z")}`, Options{
+		Location:   time.FixedZone("CEST", 2*60*60),
+		DateLayout: "%d/%m/%Y %H:%M",
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]interface{}{"Created": time.Date(2020, 5, 1, 22, 30, 0, 0, time.UTC)}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), `<p>02/05/2020 00:30 2020-05-02T00:30:00&#43;02:00</p>`, t)
+}
+
+type testProduct struct {
+	Name     string
+	Category string
+	Price    int
+}
+
+func Test_CollectionHelpers(t *testing.T) {
+	products := []testProduct{
+		{"Pear", "fruit", 3},
+		{"Kale", "vegetable", 4},
+		{"Apple", "fruit", 2},
+	}
+
+	res, err := run(`
+		p #{first(Products).Name} #{last(Products).Name} #{sum("Price", Products)} #{sum(Numbers)}
+		each $p in Products | sortBy "Name"
+			i #{$p.Name}
+		each $p in sortBy("-Price", Products)
+			b #{$p.Name}
+		each $g in groupBy("Category", Products)
+			p #{$g.Key}: #{len($g.Items)}
+		each $c in chunk(2, Numbers)
+			p #{join(",", $c)}
+		p #{join(",", reverse(Numbers))} #{join(",", uniq(Numbers))}
+		p #{join(",", keys(Stock))} #{join(",", values(Stock))} #{join(",", sortBy("n", Rows) |> reverse)}`, map[string]interface{}{
+		"Products": products,
+		"Numbers":  []int{1, 2, 2, 3, 1},
+		"Stock":    map[string]int{"b": 2, "c": 3, "a": 1},
+		"Rows":     []map[string]int{{"n": 2}, {"n": 1}},
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>Pear Apple 9 9</p><i>Apple</i><i>Kale</i><i>Pear</i><b>Kale</b><b>Pear</b><b>Apple</b><p>fruit: 2</p><p>vegetable: 1</p><p>1,2</p><p>2,3</p><p>1</p><p>1,3,2,2,1 1,2,3</p><p>a,b,c 1,2,3 map[n:2],map[n:1]</p>`, t)
+	}
+}
+
+func Test_CollectionFilter(t *testing.T) {
+	res, err := run(`
+		each $p in filter("Category", "fruit", Products)
+			i #{$p.Name}
+		each $u in Users | filter "Active"
+			b #{$u.Name}`, map[string]interface{}{
+		"Products": []testProduct{{"Pear", "fruit", 3}, {"Kale", "vegetable", 4}, {"Apple", "fruit", 2}},
+		"Users": map[string]map[string]interface{}{
+			"c": {"Name": "Cid", "Active": true},
+			"a": {"Name": "Ann", "Active": 1},
+			"b": {"Name": "Bob", "Active": false},
+			"d": {"Name": "Dan"},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<i>Pear</i><i>Apple</i><b>Ann</b><b>Cid</b>`, t)
+	}
+}
+
+func Test_CollectionHelpersOnPointers(t *testing.T) {
+	stock := map[string]int{"b": 2, "a": 1}
+	res, err := run(`p #{join(",", keys(Stock))} #{join(",", values(Stock))}`, map[string]interface{}{"Stock": &stock})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>a,b 1,2</p>`, t)
+	}
+}
+
+type testVisitor struct {
+	Name string
+}
+
+func (v testVisitor) Locale() string {
+	return "ru"
+}
+
+func Test_Translations(t *testing.T) {
+	catalog := NewCatalog()
+	catalog.Add("de", "Welcome back, {0}", "Willkommen zurück, {0}")
+	catalog.AddPlural("de", "{0} item", map[string]string{"one": "{0} Artikel", "other": "{0} Artikel"})
+
+	err := catalog.LoadJSON("ru", strings.NewReader(`{
+		"Welcome back, {0}": "С возвращением, {0}",
+		"{0} item": {"one": "{0} товар", "few": "{0} товара", "many": "{0} товаров"}
+	}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tmpl, err := Compile(`
+p~ Welcome back, #{Name}
+each $n in [1, 3, 5, 21]
+	i= tn("{0} item", "{0} items", $n)
+p #{t("Logout")}`, Options{Catalog: catalog})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	render := func(data interface{}) string {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			t.Fatal(err.Error())
+		}
+		return strings.TrimSpace(buf.String())
+	}
+
+	expect(render(map[string]string{"Name": "Ann"}), `<p>Welcome back, Ann</p><i>1 item</i><i>3 items</i><i>5 items</i><i>21 items</i><p>Logout</p>`, t)
+	expect(render(WithLocale(map[string]string{"Name": "Ann"}, "de-AT")), `<p>Willkommen zurück, Ann</p><i>1 Artikel</i><i>3 Artikel</i><i>5 Artikel</i><i>21 Artikel</i><p>Logout</p>`, t)
+	expect(render(testVisitor{"Аня"}), `<p>С возвращением, Аня</p><i>1 товар</i><i>3 товара</i><i>5 товаров</i><i>21 товар</i><p>Logout</p>`, t)
+}
+
+func Test_WithLocaleWithoutTranslations(t *testing.T) {
+	tmpl, err := Compile(`p #{Name}`, Options{Catalog: NewCatalog()})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, WithLocale(map[string]string{"Name": "Ann"}, "de")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), `<p>Ann</p>`, t)
+}
+
+func Test_CatalogPO(t *testing.T) {
+	catalog := NewCatalog()
+	err := catalog.LoadPO("pl", strings.NewReader(`
+msgid ""
+msgstr ""
+"Plural-Forms: nplurals=3; plural=(n==1 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);\n"
+
+#: index.jade:3
+msgid "{0} file"
+msgid_plural "{0} files"
+msgstr[0] "{0} plik"
+msgstr[1] "{0} pliki"
+msgstr[2] "{0} plików"
+
+#, fuzzy
+msgid "Save"
+msgstr "Zapisz"
+
+msgid "Open"
+msgstr ""
+"Otwórz"
+`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var results []string
+	for _, n := range []int{1, 2, 5, 22} {
+		results = append(results, catalog.TranslatePlural("pl", "{0} file", "{0} files", n))
+	}
+	results = append(results, catalog.Translate("pl", "Save"), catalog.Translate("pl_PL", "Open"))
+
+	expect(strings.Join(results, "|"), `1 plik|2 pliki|5 plików|22 pliki|Save|Otwórz`, t)
+
+	err = catalog.LoadPO("cs", strings.NewReader(`
+msgid ""
+msgstr ""
+"Plural-Forms: nplurals=3; plural=(n==1) ? 0 : (n>=2 && n<=4) ? 1 : 2;\n"
+
+msgid "{0} file"
+msgid_plural "{0} files"
+msgstr[0] "{0} soubor"
+msgstr[1] "{0} soubory"
+msgstr[2] "{0} souborů"
+`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	results = nil
+	for _, n := range []interface{}{1, 3, 5, 1.5} {
+		results = append(results, catalog.TranslatePlural("cs", "{0} file", "{0} files", n))
+	}
+
+	expect(strings.Join(results, "|"), `1 soubor|3 soubory|5 souborů|1.5 souborů`, t)
+
+	err = catalog.LoadPO("de", strings.NewReader(`
+msgid "Open"
+msgstr "Öffnen"
+#, fuzzy
+msgid "Save"
+msgstr "Sichern"
+msgctxt "menu"
+msgid "Close"
+msgstr "Beenden"
+
+msgid "Close"
+msgstr "Schließen"
+`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.Join([]string{
+		catalog.Translate("de", "Open"),
+		catalog.Translate("de", "Save"),
+		catalog.Translate("de", "Close"),
+		catalog.Translate("de", "menu\x04Close"),
+	}, "|"), `Öffnen|Save|Schließen|Beenden`, t)
+
+	err = catalog.LoadPO("pl", strings.NewReader(`
+msgid ""
+msgstr "Plural-Forms: nplurals=3; plural=(n==1 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);\n"
+
+msgid "{0} file"
+msgid_plural "{0} files"
+msgstr[0] "{0} plik"
+msgstr[1] "{0} pliki"
+`))
+	expect(fmt.Sprint(err), `line 5: msgid "{0} file" has 2 plural forms, Plural-Forms specifies 3`, t)
+
+	err = catalog.LoadPO("ja", strings.NewReader(`
+msgid ""
+msgstr "Plural-Forms: nplurals=2; plural=(n != 1);\n"
+`))
+	expect(fmt.Sprint(err), `line 2: Plural-Forms do not match the plural rules of ja: forms 1 and 0 are both "other"`, t)
+}
+
+func Test_ExtractMessages(t *testing.T) {
+	messages, err := ExtractDir("test/extract", DefaultDirOptions, DefaultOptions)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := WritePOT(&buf, messages); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(buf.String(), `msgid ""
+msgstr ""
+"Content-Type: text/plain; charset=UTF-8\n"
+
+#: test/extract/partials/layout.jade:3
+msgid "Shop"
+msgstr ""
+
+#: test/extract/index.jade:4
+msgid "Welcome back, {0}"
+msgstr ""
+
+#: test/extract/index.jade:5
+msgid "{0} item"
+msgid_plural "{0} items"
+msgstr[0] ""
+msgstr[1] ""
+
+#: test/extract/index.jade:6
+msgid "Sign out"
+msgstr ""
+
+#: test/extract/index.jade:6
+msgid "Logout"
+msgstr ""
+`, t)
+}
+
+func Test_WritePOTEscapes(t *testing.T) {
+	var buf bytes.Buffer
+	err := WritePOT(&buf, []*Message{{Key: "Grüße, \"{0}\"\n\tC:\\", Plural: "ß"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(buf.String(), `msgid ""
+msgstr ""
+"Content-Type: text/plain; charset=UTF-8\n"
+
+msgid "Grüße, \"{0}\"\n\tC:\\"
+msgid_plural "ß"
+msgstr[0] ""
+msgstr[1] ""
+`, t)
+}
+
+func Test_NodePositions(t *testing.T) {
+	p, err := parser.StringParser("div\n\tp Hello #{Name}\n\tif Ok\n\t\tspan= Name\n\t| text")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var positions []string
+	var walk func(node parser.Node)
+	walk = func(node parser.Node) {
+		switch node := node.(type) {
+		case *parser.Block:
+			if node != nil {
+				for _, child := range node.Children {
+					walk(child)
+				}
+			}
+			return
+		case *parser.Tag:
+			defer walk(node.Block)
+		case *parser.Condition:
+			defer walk(node.Positive)
+		}
+
+		pos := node.Pos()
+		positions = append(positions, fmt.Sprintf("%T %d:%d", node, pos.LineNum, pos.ColNum))
+	}
+	walk(p.Parse())
+
+	expect(strings.Join(positions, ", "), "*parser.Tag 1:1, *parser.Tag 2:2, *parser.Text 2:3, *parser.Condition 3:2, *parser.Tag 4:3, *parser.Buffered 4:7, *parser.Text 5:2", t)
+}
+
+func Test_UnescapedInterpolation(t *testing.T) {
+	res, err := run(`
+		p Hello #{Html} and !{Html}, \#{Name} is literal
+		a(href="/users/#{Name}", title="!{Name} \!{x}") #{Name}`, map[string]string{"Html": "<b>bold</b>", "Name": "ann"})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>Hello &lt;b&gt;bold&lt;/b&gt; and <b>bold</b>, #{Name} is literal</p><a href="/users/ann" title="ann !{x}">ann</a>`, t)
+	}
+}
+
+func Test_NestedInterpolation(t *testing.T) {
+	res, err := run(`
+		p #{ {"a": "}"}["a"] } #{len({})} #{"{" + Name + '}'} {{ #{tn("{0} item", "{0} items", 2)}`, map[string]string{"Name": "ann"})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>} 0 {ann} {{ 2 items</p>`, t)
+	}
+}
+
+func Test_UnterminatedInterpolation(t *testing.T) {
+	_, err := run("div\n  p Hello #{Name + \"}\"", nil)
+
+	if err == nil || !strings.Contains(err.Error(), "Unterminated interpolation - Line: 2, Column: 11") {
+		t.Fatalf("Expected unterminated interpolation error, got %v", err)
+	}
+}
+
+func Test_UnterminatedInterpolationInRawText(t *testing.T) {
+	res, err := run("script.\n\tvar name = \"#{Name}\", open = \"!{\";\nstyle\n\ta::before { content: \"#{\" }", map[string]string{"Name": "ann"})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<script>var name = "ann", open = "!{";</script><style>a::before { content: "#{" }</style>`, t)
+	}
+}
+
+func Test_TagInterpolation(t *testing.T) {
+	res, err := run(`
+		p Read the #[a.link(href="/terms", title="[x]") terms] first, #[strong don't #[em ever] skip] it
+		p
+			| Hi #[b #{Names[0]}] \#[literal]`, map[string][]string{"Names": {"ann"}})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>Read the <a class="link" href="/terms" title="[x]">terms</a> first, <strong>don't <em>ever</em> skip</strong> it</p><p>Hi <b>ann</b> #[literal]</p>`, t)
+	}
+}
+
+func Test_TagInterpolationPretty(t *testing.T) {
+	tmpl, err := Compile("div\n\tp See #[a(href=\"/\") home] now\n\tp\n\t\t| One #[b two] three", Options{PrettyPrint: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div>\n\t<p>See <a href=\"/\">home</a> now</p>\n\t<p>One <b>two</b> three</p>\n</div>", t)
+}
+
+func Test_TagInterpolationErrors(t *testing.T) {
+	// Errors within interpolated tags are reported at their position in the line of the text
+	for tpl, expected := range map[string]string{
+		"div\n\tp Hello #[a #{x] y":        "Unterminated interpolation - Line: 2, Column: 14",
+		"p Hi #[b= 1 +] y":                 "Unable to parse expression: 1 + - Line: 1, Column: 9",
+		"div\n\tp\n\t\t| Hello #[b x] #{y": "Unterminated interpolation - Line: 3, Column: 18",
+		"p Hi #[b #[i #{x]] y":             "Unterminated interpolation - Line: 1, Column: 14",
+	} {
+		if _, err := run(tpl, nil); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %q, got %v", expected, tpl, err)
+		}
+	}
+}
+
+func Test_DotTextBlock(t *testing.T) {
+	res, err := run(`div
+						p.
+							Hello #{Name},
+							<b>welcome</b> back.
+						div.box(title="x").
+							| kept as text
+						script.
+							var name = "#{Name}";
+						p
+							.`, map[string]string{"Name": "ann"})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<div><p>Hello ann,\n<b>welcome</b> back.</p><div class=\"box\" title=\"x\">| kept as text</div><script>var name = \"ann\";</script><p>.</p></div>", t)
+	}
+}
+
+func Test_DotTextBlockNesting(t *testing.T) {
+	// Text blocks ending the input or outdented by several levels at once
+	for tpl, expected := range map[string]string{
+		"p.\n\ta\n\t\tb":                        "<p>a\n\tb</p>",
+		"div\n\t:cdata\n\t\ta\n\t\t\tb":         "<div><![CDATA[a\n\tb]]></div>",
+		"div\n\tp.\n\t\ta\n\t\t\tb\nspan c":     "<div><p>a\n\tb</p></div><span>c</span>",
+		"div\n\tdiv\n\t\tp.\n\t\t\ta\n\tspan c": "<div><div><p>a</p></div><span>c</span></div>",
+	} {
+		res, err := run(tpl, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(res, expected, t)
+	}
+}
+
+func Test_Filters(t *testing.T) {
+	tmpl, err := Compile(`div
+	:escape <b>&</b>
+	:shout(suffix="!!", quiet)
+		hello #{Name}
+		{{world}}`, Options{Filters: map[string]Filter{
+		"shout": func(text string, options map[string]string) (string, error) {
+			return strings.ToUpper(text) + options["suffix"] + options["quiet"], nil
+		},
+	}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div>&lt;b&gt;&amp;&lt;/b&gt;HELLO #{NAME}\n{{WORLD}}!!true</div>", t)
+}
+
+func Test_MarkdownFence(t *testing.T) {
+	tmpl, err := Compile("div\n\t:markdown\n\t\t```\n\t\tif a {\n\t\t\tb()\n\t\t}\n\t\t```", DefaultOptions)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div>\n\t<pre><code>if a {\n\tb()\n}</code></pre>\n</div>", t)
+}
+
+func Test_FilterErrors(t *testing.T) {
+	if _, err := run(`:unknown text`, nil); err == nil || !strings.Contains(err.Error(), "Unknown filter: unknown - Line: 1") {
+		t.Fatalf("Expected unknown filter error, got %v", err)
+	}
+
+	_, err := Compile(`p
+	:fail text`, Options{Filters: map[string]Filter{
+		"fail": func(text string, options map[string]string) (string, error) {
+			return "", errors.New("broken")
+		},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "Filter fail failed: broken - Line: 2") {
+		t.Fatalf("Expected filter error, got %v", err)
+	}
+}
+
+func Test_RawInclude(t *testing.T) {
+	tmpl, err := CompileFile("test/cases/raw-include.jade", Options{Fs: DefaultOptions.Fs, PathSeparator: DefaultOptions.PathSeparator})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), `<html><head><style>body { color: #333 }</style><script>var ready = 1 < 2;</script></head><body><span class="icon"><svg viewBox="0 0 8 8"><path d="M0 0h8v8z"/></svg></span><p>Terms &amp; {{conditions}} &lt;apply&gt;</p></body></html>`, t)
+}
+
+func Test_DynamicInclude(t *testing.T) {
+	catalog := NewCatalog()
+	catalog.Add("de", "Hello", "Hallo")
+
+	tmpls, err := CompileDir("test/include", DefaultDirOptions, Options{Fs: DefaultOptions.Fs, PathSeparator: DefaultOptions.PathSeparator, Catalog: catalog})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	widgets := []map[string]interface{}{
+		{"Kind": "banner", "Title": "Sale"},
+		{"Kind": "list.jade", "Items": []string{"a", "b"}},
+		{"Kind": "greeting"},
+	}
+
+	var buf bytes.Buffer
+	if err := tmpls["page"].Execute(&buf, map[string]interface{}{"Widgets": widgets}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div><p class=\"banner\">Sale</p><ul><li>a</li><li>b</li></ul><p>Hello</p></div>", t)
+
+	// Included templates are rendered in the locale of the including template
+	buf.Reset()
+	if err := tmpls["page"].Execute(&buf, WithLocale(map[string]interface{}{"Widgets": widgets[2:]}, "de")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div><p>Hallo</p></div>", t)
+
+	tmpl, err := Compile(`include #{Name}`, Options{Templates: tmpls})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := tmpl.Execute(&buf, map[string]string{"Name": "missing"}); err == nil || !strings.Contains(err.Error(), "unknown template: missing") {
+		t.Fatalf("Expected unknown template error, got %v", err)
+	}
+
+	// Templates compiled without a catalog render the data of a localized page
+	plain, err := Compile(`h1 #{Title}`, Options{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	page, err := Compile("div\n\tinclude #{Name}", Options{PrettyPrint: true, Catalog: catalog, Templates: map[string]*template.Template{"plain": plain}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf.Reset()
+	if err := page.Execute(&buf, WithLocale(map[string]string{"Name": "plain", "Title": "News"}, "de")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div>\n\t<h1>News</h1>\n</div>", t)
+}
+
+func Test_DynamicIncludeIndent(t *testing.T) {
+	list, err := Compile("ul\n\tli a\n\tli b", DefaultOptions)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	opts := DefaultOptions
+	opts.Templates = map[string]*template.Template{"list": list}
+	page, err := Compile("div\n\tsection\n\t\tinclude #{Name}", opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, map[string]string{"Name": "list"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div>\n\t<section>\n\t\t<ul>\n\t\t\t<li>a</li>\n\t\t\t<li>b</li>\n\t\t</ul>\n\t</section>\n</div>", t)
+}
+
+func Test_DynamicIncludeRecursion(t *testing.T) {
+	templates := make(map[string]*template.Template)
+	loop, err := Compile(`p: include #{Name}`, Options{Templates: templates})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	templates["loop"] = loop
+
+	err = loop.Execute(ioutil.Discard, map[string]string{"Name": "loop"})
+	if err == nil || !strings.Contains(err.Error(), "exceeded maximum include depth (1000) including loop") || len(err.Error()) > 500 {
+		t.Fatalf("Expected include depth error, got %v", err)
+	}
+}
+
+func Test_HelperNamesAsData(t *testing.T) {
+	res, err := run(`p #{title} #{date} #{first} #{number} #{title(first)} #{first |> title} #{"x" | upper}`, map[string]string{
+		"title":  "Hello",
+		"date":   "today",
+		"first":  "ann",
+		"number": "7",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<p>Hello today ann 7 Ann Ann X</p>`, t)
+	}
+}
+
 func Failing_Test_CompileDir(t *testing.T) {
 	tmpl, err := CompileDir("samples/", DefaultDirOptions, DefaultOptions)
 
@@ -0,0 +1,419 @@
+package jade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// All runtime operators convert their operands into numbers first. Any integer, unsigned integer or
+// floating point kind is accepted, including named types, pointers to numbers, json.Number and *big.Int.
+
+var (
+	errOverflow       = errors.New("integer overflow")
+	errDivisionByZero = errors.New("division by zero")
+	errNotInteger     = errors.New("operands must be integers")
+)
+
+const (
+	numberInt = iota
+	numberUint
+	numberFloat
+	numberBig
+)
+
+type number struct {
+	kind int
+	i    int64
+	u    uint64
+	f    float64
+	b    *big.Int
+	// Go type of the operand, nil for json.Number and *big.Int
+	typ reflect.Type
+}
+
+var (
+	bigIntType     = reflect.TypeOf((*big.Int)(nil))
+	bigIntValType  = reflect.TypeOf(big.Int{})
+	jsonNumberType = reflect.TypeOf(json.Number(""))
+	int64Type      = reflect.TypeOf(int64(0))
+	uint64Type     = reflect.TypeOf(uint64(0))
+	float64Type    = reflect.TypeOf(float64(0))
+)
+
+func toNumber(x interface{}) (number, bool) {
+	vx := reflect.ValueOf(x)
+	for vx.Kind() == reflect.Ptr && !vx.IsNil() && vx.Type() != bigIntType {
+		vx = vx.Elem()
+	}
+
+	if !vx.IsValid() {
+		return number{}, false
+	}
+
+	switch vx.Type() {
+	case bigIntType:
+		if vx.IsNil() {
+			return number{}, false
+		}
+		return number{kind: numberBig, b: vx.Interface().(*big.Int)}, true
+	case bigIntValType:
+		b := vx.Interface().(big.Int)
+		return number{kind: numberBig, b: &b}, true
+	case jsonNumberType:
+		n := vx.Interface().(json.Number)
+		if i, err := n.Int64(); err == nil {
+			return number{kind: numberInt, i: i}, true
+		}
+		if f, err := n.Float64(); err == nil {
+			return number{kind: numberFloat, f: f}, true
+		}
+		return number{}, false
+	}
+
+	switch vx.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{kind: numberInt, i: vx.Int(), typ: vx.Type()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return number{kind: numberUint, u: vx.Uint(), typ: vx.Type()}, true
+	case reflect.Float32, reflect.Float64:
+		return number{kind: numberFloat, f: vx.Float(), typ: vx.Type()}, true
+	}
+
+	return number{}, false
+}
+
+func (n number) isInteger() bool {
+	return n.kind != numberFloat
+}
+
+func (n number) float() float64 {
+	switch n.kind {
+	case numberInt:
+		return float64(n.i)
+	case numberUint:
+		return float64(n.u)
+	case numberBig:
+		f, _ := new(big.Float).SetInt(n.b).Float64()
+		return f
+	}
+
+	return n.f
+}
+
+func (n number) bigInt() *big.Int {
+	switch n.kind {
+	case numberInt:
+		return big.NewInt(n.i)
+	case numberUint:
+		return new(big.Int).SetUint64(n.u)
+	case numberBig:
+		return n.b
+	}
+
+	b, _ := big.NewFloat(n.f).Int(nil)
+	return b
+}
+
+func (n number) String() string {
+	switch n.kind {
+	case numberInt:
+		return fmt.Sprintf("%d", n.i)
+	case numberUint:
+		return fmt.Sprintf("%d", n.u)
+	case numberBig:
+		return n.b.String()
+	}
+
+	return strconv.FormatFloat(n.f, 'f', -1, n.floatBits())
+}
+
+// Returns the precision of floating point numbers, float32 values are formatted with their shortest
+// 32 bit representation so that float32(0.1) renders as 0.1.
+func (n number) floatBits() int {
+	if n.typ != nil && n.typ.Kind() == reflect.Float32 {
+		return 32
+	}
+
+	return 64
+}
+
+// Group and decimal separators used to format numbers, keyed by locale or language.
+var numberSeparators = map[string][2]string{
+	"en":    {",", "."},
+	"ja":    {",", "."},
+	"zh":    {",", "."},
+	"ko":    {",", "."},
+	"de":    {".", ","},
+	"de-ch": {"’", "."},
+	"es":    {".", ","},
+	"it":    {".", ","},
+	"nl":    {".", ","},
+	"pt":    {".", ","},
+	"da":    {".", ","},
+	"id":    {".", ","},
+	"tr":    {".", ","},
+	"fr":    {" ", ","},
+	"fr-ch": {" ", "."},
+	"ru":    {" ", ","},
+	"uk":    {" ", ","},
+	"pl":    {" ", ","},
+	"cs":    {" ", ","},
+	"sv":    {" ", ","},
+	"fi":    {" ", ","},
+	"nb":    {" ", ","},
+}
+
+// Returns the group and decimal separators of locale, falling back to its language and then to English.
+func localeSeparators(locale string) (string, string) {
+	locale = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+
+	if sep, ok := numberSeparators[locale]; ok {
+		return sep[0], sep[1]
+	}
+
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		if sep, ok := numberSeparators[locale[:i]]; ok {
+			return sep[0], sep[1]
+		}
+	}
+
+	sep := numberSeparators["en"]
+	return sep[0], sep[1]
+}
+
+// Formats n with the given number of decimals, -1 uses as many as needed. Digits of the integer part are
+// grouped in threes using the group separator, unless it is empty.
+func formatNumber(n number, precision int, group, decimal string) string {
+	var digits string
+
+	if n.isInteger() {
+		digits = n.String()
+		if precision > 0 {
+			digits += "." + strings.Repeat("0", precision)
+		}
+	} else if math.IsNaN(n.f) || math.IsInf(n.f, 0) {
+		return n.String()
+	} else {
+		digits = strconv.FormatFloat(n.f, 'f', precision, n.floatBits())
+	}
+
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	integer, fraction := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		integer, fraction = digits[:i], digits[i+1:]
+	}
+
+	if len(group) > 0 {
+		var buf strings.Builder
+
+		for i, ch := range integer {
+			if i > 0 && (len(integer)-i)%3 == 0 {
+				buf.WriteString(group)
+			}
+			buf.WriteRune(ch)
+		}
+
+		integer = buf.String()
+	}
+
+	if len(fraction) > 0 {
+		return sign + integer + decimal + fraction
+	}
+
+	return sign + integer
+}
+
+// Converts an integer result back into a Go value. Results keep the type of the operands if both share
+// the same type, otherwise int64 or uint64 (for unsigned operands) are used. Operations involving a
+// *big.Int yield a *big.Int.
+func integerResult(r *big.Int, x, y number) (interface{}, error) {
+	if x.kind == numberBig || y.kind == numberBig {
+		return r, nil
+	}
+
+	typ := int64Type
+	if x.typ != nil && x.typ == y.typ {
+		typ = x.typ
+	} else if x.kind == numberUint && y.kind == numberUint {
+		typ = uint64Type
+	}
+
+	result := reflect.New(typ).Elem()
+
+	if result.Kind() >= reflect.Uint && result.Kind() <= reflect.Uintptr {
+		if !r.IsUint64() || result.OverflowUint(r.Uint64()) {
+			return nil, errOverflow
+		}
+		result.SetUint(r.Uint64())
+	} else {
+		if !r.IsInt64() || result.OverflowInt(r.Int64()) {
+			return nil, errOverflow
+		}
+		result.SetInt(r.Int64())
+	}
+
+	return result.Interface(), nil
+}
+
+// Converts a floating point result back into a Go value, float32 operands yield a float32.
+func floatResult(r float64, x, y number) interface{} {
+	if x.typ != nil && x.typ == y.typ && x.typ != float64Type {
+		result := reflect.New(x.typ).Elem()
+		result.SetFloat(r)
+		return result.Interface()
+	}
+
+	return r
+}
+
+func numericBinary(x, y number, intOp func(r, a, b *big.Int) error, floatOp func(a, b float64) (float64, error)) (interface{}, error) {
+	if !x.isInteger() || !y.isInteger() {
+		if floatOp == nil {
+			return nil, errNotInteger
+		}
+
+		r, err := floatOp(x.float(), y.float())
+		if err != nil {
+			return nil, err
+		}
+
+		return floatResult(r, x, y), nil
+	}
+
+	r := new(big.Int)
+	if err := intOp(r, x.bigInt(), y.bigInt()); err != nil {
+		return nil, err
+	}
+
+	return integerResult(r, x, y)
+}
+
+func numericAdd(x, y number) (interface{}, error) {
+	return numericBinary(x, y,
+		func(r, a, b *big.Int) error { r.Add(a, b); return nil },
+		func(a, b float64) (float64, error) { return a + b, nil })
+}
+
+func numericSub(x, y number) (interface{}, error) {
+	return numericBinary(x, y,
+		func(r, a, b *big.Int) error { r.Sub(a, b); return nil },
+		func(a, b float64) (float64, error) { return a - b, nil })
+}
+
+func numericMul(x, y number) (interface{}, error) {
+	return numericBinary(x, y,
+		func(r, a, b *big.Int) error { r.Mul(a, b); return nil },
+		func(a, b float64) (float64, error) { return a * b, nil })
+}
+
+func numericQuo(x, y number) (interface{}, error) {
+	return numericBinary(x, y,
+		func(r, a, b *big.Int) error {
+			if b.Sign() == 0 {
+				return errDivisionByZero
+			}
+			r.Quo(a, b)
+			return nil
+		},
+		func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errDivisionByZero
+			}
+			return a / b, nil
+		})
+}
+
+func numericRem(x, y number) (interface{}, error) {
+	return numericBinary(x, y,
+		func(r, a, b *big.Int) error {
+			if b.Sign() == 0 {
+				return errDivisionByZero
+			}
+			r.Rem(a, b)
+			return nil
+		},
+		func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errDivisionByZero
+			}
+			return math.Mod(a, b), nil
+		})
+}
+
+func numericBitwise(x, y number, op func(r, a, b *big.Int) *big.Int) (interface{}, error) {
+	return numericBinary(x, y, func(r, a, b *big.Int) error { op(r, a, b); return nil }, nil)
+}
+
+func numericShift(x, y number, left bool) (interface{}, error) {
+	if !x.isInteger() || !y.isInteger() {
+		return nil, errNotInteger
+	}
+
+	count := y.bigInt()
+	if count.Sign() < 0 || !count.IsInt64() || count.Int64() > 1024 {
+		return nil, fmt.Errorf("invalid shift count %s", count)
+	}
+
+	r := new(big.Int)
+	if left {
+		r.Lsh(x.bigInt(), uint(count.Int64()))
+	} else {
+		r.Rsh(x.bigInt(), uint(count.Int64()))
+	}
+
+	// The result has the type of the shifted operand
+	return integerResult(r, x, x)
+}
+
+func numericComplement(x number) (interface{}, error) {
+	if !x.isInteger() {
+		return nil, errNotInteger
+	}
+
+	if x.kind == numberUint {
+		mask := new(big.Int).Lsh(big.NewInt(1), uint(x.typ.Bits()))
+		mask.Sub(mask, big.NewInt(1))
+		return integerResult(new(big.Int).Xor(x.bigInt(), mask), x, x)
+	}
+
+	return integerResult(new(big.Int).Not(x.bigInt()), x, x)
+}
+
+func numericNegate(x number) (interface{}, error) {
+	if !x.isInteger() {
+		return floatResult(-x.f, x, x), nil
+	}
+
+	r := new(big.Int).Neg(x.bigInt())
+	if x.kind == numberUint {
+		// Negated unsigned integers become signed
+		return integerResult(r, number{kind: numberInt}, number{kind: numberInt})
+	}
+
+	return integerResult(r, x, x)
+}
+
+// Returns -1, 0 or +1 depending on whether x is less than, equal to or greater than y.
+func compareNumbers(x, y number) int {
+	if !x.isInteger() || !y.isInteger() {
+		fx, fy := x.float(), y.float()
+		if fx < fy {
+			return -1
+		} else if fx > fy {
+			return 1
+		}
+		return 0
+	}
+
+	return x.bigInt().Cmp(y.bigInt())
+}
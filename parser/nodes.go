@@ -81,6 +81,12 @@ type Text struct {
 	SourcePosition
 	Value string
 	Raw   bool
+	// Translate is set for text blocks written with the `~` shorthand, they are rendered through the
+	// translation catalog.
+	Translate bool
+	// Verbatim is set for the contents of included non Jade files, they are written without
+	// interpolation.
+	Verbatim bool
 }
 
 func newText(value string, raw bool) *Text {
@@ -90,6 +96,35 @@ func newText(value string, raw bool) *Text {
 	return dt
 }
 
+// Filter is a text block transformed at compile time, written as `:name` followed by an indented
+// block of text, or included from a file with `include:name file`.
+type Filter struct {
+	SourcePosition
+	Name    string
+	Options map[string]string
+	Text    string
+}
+
+func newFilter(name string, options map[string]string) *Filter {
+	filter := new(Filter)
+	filter.Name = name
+	filter.Options = options
+	return filter
+}
+
+// DynamicInclude includes a template chosen at execution time, written as `include #{expression}`.
+// The path may mix text and interpolations, like `include widgets/#{Widget.Kind}`.
+type DynamicInclude struct {
+	SourcePosition
+	Path string
+}
+
+func newDynamicInclude(path string) *DynamicInclude {
+	include := new(DynamicInclude)
+	include.Path = path
+	return include
+}
+
 type Block struct {
 	SourcePosition
 	Children []Node
@@ -114,16 +149,27 @@ func (b *Block) CanInline() bool {
 		return true
 	}
 
-	allText := true
-
 	for _, child := range b.Children {
-		if txt, ok := child.(*Text); !ok || txt.Raw {
-			allText = false
-			break
+		switch child := child.(type) {
+		case *Text:
+			if child.Raw {
+				return false
+			}
+		case *Tag:
+			// Tags interpolated into text with #[tag text]
+			if !child.IsInterpolated {
+				return false
+			}
+		case *Block:
+			if !child.CanInline() {
+				return false
+			}
+		default:
+			return false
 		}
 	}
 
-	return allText
+	return true
 }
 
 const (
@@ -159,7 +205,9 @@ type Tag struct {
 	Block          *Block
 	Name           string
 	IsInterpolated bool
-	Attributes     []Attribute
+	// HasTextBlock is set for tags written with a trailing dot, their indented block is plain text.
+	HasTextBlock bool
+	Attributes   []Attribute
 }
 
 func newTag(name string) *Tag {
@@ -183,7 +231,7 @@ func (t *Tag) IsSelfClosing() bool {
 }
 
 func (t *Tag) IsRawText() bool {
-	return t.Name == "style" || t.Name == "script"
+	return t.HasTextBlock || t.Name == "style" || t.Name == "script"
 }
 
 type Condition struct {
@@ -226,19 +274,38 @@ func newBuffered(expression string, escaped bool) *Buffered {
 	return buff
 }
 
+const (
+	AssignmentDefault = iota
+	AssignmentDeclare
+	AssignmentReassign
+)
+
 type Assignment struct {
 	SourcePosition
 	X          string
 	Expression string
+	Mode       int
 }
 
 func newAssignment(x, expression string) *Assignment {
 	assgn := new(Assignment)
 	assgn.X = x
 	assgn.Expression = expression
+	assgn.Mode = AssignmentDefault
 	return assgn
 }
 
+type Code struct {
+	SourcePosition
+	Expression string
+}
+
+func newCode(expression string) *Code {
+	code := new(Code)
+	code.Expression = expression
+	return code
+}
+
 type Mixin struct {
 	SourcePosition
 	Block *Block
@@ -273,22 +340,34 @@ type MixinCall struct {
 func newMixinCall(name, args string) *MixinCall {
 	mixinCall := new(MixinCall)
 	mixinCall.Name = name
-
-	const t = "%s"
-	quoteExp := regexp.MustCompile(`"(.*?)"`)
-	delExp := regexp.MustCompile(`,\s`)
-
-	quotes := quoteExp.FindAllString(args, -1)
-	replaced := quoteExp.ReplaceAllString(args, t)
-	mixinCall.Args = delExp.Split(replaced, -1)
-
-	qi := 0
-	for i, arg := range mixinCall.Args {
-		if arg == t {
-			mixinCall.Args[i] = quotes[qi]
-			qi++
+	mixinCall.Args = make([]string, 0)
+
+	// Split arguments at commas which are neither quoted nor nested within brackets
+	depth, start := 0, 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '"', '\'', '`':
+			quote := args[i]
+			for i++; i < len(args) && args[i] != quote; i++ {
+				if args[i] == '\\' && quote != '`' {
+					i++
+				}
+			}
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				mixinCall.Args = append(mixinCall.Args, strings.TrimSpace(args[start:i]))
+				start = i + 1
+			}
 		}
 	}
 
+	if last := strings.TrimSpace(args[start:]); len(last) > 0 || len(mixinCall.Args) > 0 {
+		mixinCall.Args = append(mixinCall.Args, last)
+	}
+
 	return mixinCall
 }
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/go-floki/jade/path"
+	"html"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -21,9 +23,12 @@ type Parser struct {
 	fs            http.FileSystem
 	pathSeparator rune
 	currenttoken  *token
-	namedBlocks   map[string]*NamedBlock
-	parent        *Parser
-	result        *Block
+	// Positions of the current token and of the token consumed before it
+	tokenPos    SourcePosition
+	lastPos     SourcePosition
+	namedBlocks map[string]*NamedBlock
+	parent      *Parser
+	result      *Block
 }
 
 func newParser(rdr io.Reader) *Parser {
@@ -70,6 +75,10 @@ func (p *Parser) FileSystem(fs http.FileSystem) {
 	p.fs = fs
 }
 
+func (p *Parser) PathSeparator(pathSeparator rune) {
+	p.pathSeparator = pathSeparator
+}
+
 func (p *Parser) Parse() *Block {
 	if p.result != nil {
 		return p.result
@@ -81,7 +90,9 @@ func (p *Parser) Parse() *Block {
 				panic(r)
 			}
 
-			pos := p.pos()
+			// Errors refer to the token at which parsing failed
+			pos := p.tokenPos
+			pos.Filename = p.filename
 
 			if len(pos.Filename) > 0 {
 				panic(fmt.Sprintf("Jade Error in <%s>: %v - Line: %d, Column: %d, Length: %d", pos.Filename, r, pos.LineNum, pos.ColNum, pos.TokenLength))
@@ -142,22 +153,26 @@ func (p *Parser) Parse() *Block {
 	return block
 }
 
+// Returns the position of the last consumed token. Nodes are created after their tokens have been
+// consumed, while the scanner already points at the following token.
 func (p *Parser) pos() SourcePosition {
-	pos := p.scanner.Pos()
+	pos := p.lastPos
 	pos.Filename = p.filename
 	return pos
 }
 
-func (p *Parser) parseRelativeFile(filename string) *Parser {
+// Returns the path of a file referenced relative to the file being parsed.
+func (p *Parser) relativeFilename(filename string) string {
 	if len(p.filename) == 0 {
 		panic("Unable to import or extend " + filename + " in a non filesystem based parser.")
 	}
 
-	filename = path.Convert(p.pathSeparator, filename, func(fileName string) string {
-		parserPath := path.ToOsSeparator(p.pathSeparator, p.filename)
-		parserPath = filepath.Dir(parserPath)
-		return filepath.Join(parserPath, filename)
-	})
+	parserPath := filepath.Dir(path.ToOsSeparator(p.pathSeparator, p.filename))
+	return path.FromOsSeparator(p.pathSeparator, filepath.Join(parserPath, path.ToOsSeparator(p.pathSeparator, filename)))
+}
+
+func (p *Parser) parseRelativeFile(filename string) *Parser {
+	filename = p.relativeFilename(filename)
 
 	if strings.IndexRune(path.Convert(p.pathSeparator, filename, filepath.Base), '.') < 0 {
 		filename = filename + ".jade"
@@ -171,6 +186,24 @@ func (p *Parser) parseRelativeFile(filename string) *Parser {
 	return parser
 }
 
+// Returns the contents of a file which is included without being parsed.
+func (p *Parser) readRelativeFile(filename string) string {
+	filename = p.relativeFilename(filename)
+
+	file, err := p.fs.Open(filename)
+	if err != nil {
+		panic("Unable to read " + filename + ", Error: " + string(err.Error()))
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		panic("Unable to read " + filename + ", Error: " + string(err.Error()))
+	}
+
+	return string(data)
+}
+
 func tokenKind2Str(token rune) string {
 	switch token {
 	case tokEOF:
@@ -223,6 +256,12 @@ func tokenKind2Str(token rune) string {
 		return "tokSemicolon"
 	case tokNewLine:
 		return "tokNewLine"
+	case tokCode:
+		return "tokCode"
+	case tokTextBlock:
+		return "tokTextBlock"
+	case tokFilter:
+		return "tokFilter"
 	}
 	return fmt.Sprintf("unknown(%d)", token)
 }
@@ -257,6 +296,16 @@ func (p *Parser) parse() Node {
 		return p.parseBuffered()
 	case tokAssignment:
 		return p.parseAssignment()
+	case tokCode:
+		return p.parseCode()
+	case tokFilter:
+		return p.parseFilter()
+	case tokTextBlock:
+		// A dot on a line of its own is plain text
+		text := newText(".", false)
+		text.SourcePosition = p.pos()
+		p.advance()
+		return text
 	case tokNamedBlock:
 		return p.parseNamedBlock()
 	case tokExtends:
@@ -299,7 +348,9 @@ func (p *Parser) expectOneOf(typ rune, typ2 rune) *token {
 }
 
 func (p *Parser) advance() {
+	p.lastPos = p.tokenPos
 	p.currenttoken = p.scanner.Next()
+	p.tokenPos = p.scanner.Pos()
 }
 
 func (p *Parser) parseExtends() *Block {
@@ -332,7 +383,7 @@ func (p *Parser) parseBlock(parent Node) *Block {
 			break
 		}
 
-		if p.currenttoken.Kind == tokBlank {
+		if p.currenttoken.Kind == tokBlank || p.currenttoken.Kind == tokNewLine {
 			p.advance()
 			continue
 		}
@@ -427,13 +478,125 @@ func (p *Parser) parseEach() *Each {
 	return ech
 }
 
-func (p *Parser) parseImport() *Block {
+func (p *Parser) parseImport() Node {
 	tok := p.expect(tokImport)
+
+	// Paths with interpolations are resolved when the template is executed
+	if strings.Contains(tok.Value, "#{") {
+		if len(tok.Data["Filter"]) > 0 {
+			panic("Filters can not be applied to dynamic includes.")
+		}
+
+		include := newDynamicInclude(tok.Value)
+		include.SourcePosition = p.pos()
+		return include
+	}
+
+	// Files included through a filter are passed to the filter instead of being parsed
+	if len(tok.Data["Filter"]) > 0 {
+		filter := newFilter(tok.Data["Filter"], parseFilterOptions(tok.Data["Options"]))
+		filter.SourcePosition = p.pos()
+		filter.Text = p.readRelativeFile(tok.Value)
+		return filter
+	}
+
+	// Files other than templates are inserted as they are, text files are escaped
+	switch strings.ToLower(filepath.Ext(tok.Value)) {
+	case ".html", ".htm", ".svg", ".css", ".js", ".txt":
+		value := strings.TrimRight(p.readRelativeFile(tok.Value), "\r\n")
+		if strings.ToLower(filepath.Ext(tok.Value)) == ".txt" {
+			value = html.EscapeString(value)
+		}
+
+		text := newText(value, true)
+		text.Verbatim = true
+		text.SourcePosition = p.pos()
+		return text
+	}
+
 	node := p.parseRelativeFile(tok.Value).Parse()
 	node.SourcePosition = p.pos()
 	return node
 }
 
+func (p *Parser) parseFilter() *Filter {
+	tok := p.expect(tokFilter)
+	filter := newFilter(tok.Value, parseFilterOptions(tok.Data["Options"]))
+	filter.SourcePosition = p.pos()
+	filter.Text = tok.Data["Text"]
+
+	if p.currenttoken.Kind == tokIndent {
+		// The indented block is read as raw text
+		p.scanner.readRaw = true
+
+		for _, child := range p.parseBlock(filter).Children {
+			if text, ok := child.(*Text); ok {
+				if len(filter.Text) > 0 {
+					filter.Text += "\n"
+				}
+				filter.Text += text.Value
+			}
+		}
+	}
+
+	return filter
+}
+
+// Parses filter options written as `:name(key="value", flag)`. Options without a value are set to
+// "true".
+func parseFilterOptions(input string) map[string]string {
+	options := make(map[string]string)
+
+	for len(input) > 0 {
+		input = strings.TrimLeft(input, ", \t")
+		if len(input) == 0 {
+			break
+		}
+
+		end := strings.IndexAny(input, "=, \t")
+		if end < 0 {
+			end = len(input)
+		}
+
+		key, value := input[:end], "true"
+		input = input[end:]
+
+		if strings.HasPrefix(input, "=") {
+			input = input[1:]
+			end = strings.IndexAny(input, ", \t")
+
+			if len(input) > 0 && (input[0] == '"' || input[0] == '\'') {
+				quote := input[0]
+				end = 1
+				for end < len(input) && input[end] != quote {
+					if input[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				end++
+			}
+
+			if end < 0 || end > len(input) {
+				end = len(input)
+			}
+
+			value = input[:end]
+			input = input[end:]
+
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+				value = value[1 : len(value)-1]
+			}
+		}
+
+		options[key] = value
+	}
+
+	return options
+}
+
 func (p *Parser) parseNamedBlock() *Block {
 	tok := p.expect(tokNamedBlock)
 
@@ -483,11 +646,113 @@ func (p *Parser) parseComment() *Comment {
 	return cmnt
 }
 
-func (p *Parser) parseText() *Text {
+func (p *Parser) parseText() Node {
 	tok := p.expect(tokText)
 	node := newText(tok.Value, tok.Data["Mode"] == "raw")
+	node.Translate = tok.Data["Mode"] == "translate"
 	node.SourcePosition = p.pos()
-	return node
+
+	if node.Raw || node.Translate || !strings.Contains(node.Value, "#[") {
+		return node
+	}
+
+	return p.parseTagInterpolations(node)
+}
+
+// Returns the index of the bracket closing the tag interpolation starting at index i. Quotes are
+// only significant within attribute lists, so that text like `#[b don't]` is possible.
+func findTagInterpolationEnd(value string, i int) int {
+	depth, parens := 0, 0
+
+	for ; i < len(value); i++ {
+		switch ch := value[i]; ch {
+		case '"', '\'':
+			if parens == 0 {
+				continue
+			}
+
+			for i++; i < len(value) && value[i] != ch; i++ {
+				if value[i] == '\\' {
+					i++
+				}
+			}
+		case '(':
+			parens++
+		case ')':
+			parens--
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Splits text at `#[tag text]` interpolations, which are parsed into tags rendered inline. Returns
+// the text itself if it has no tag interpolations, otherwise a block of text and tag nodes.
+func (p *Parser) parseTagInterpolations(text *Text) Node {
+	block := newBlock()
+	block.SourcePosition = text.SourcePosition
+
+	// Column of the first character of the text, the token of the text includes its prefix, like `| `
+	col := text.ColNum + text.TokenLength - len(text.Value)
+
+	literal, start := "", 0
+	pushText := func(end int) {
+		if len(literal) > 0 {
+			node := newText(literal, false)
+			node.SourcePosition = text.SourcePosition
+			node.ColNum, node.TokenLength = col+start, len(literal)
+			block.push(node)
+			literal = ""
+		}
+		start = end
+	}
+
+	value := text.Value
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && strings.HasPrefix(value[i+1:], "#[") {
+			literal += "#["
+			i += 2
+			continue
+		}
+
+		if !strings.HasPrefix(value[i:], "#[") {
+			literal += value[i : i+1]
+			continue
+		}
+
+		end := findTagInterpolationEnd(value, i+1)
+		if end < 0 {
+			panic("Unterminated tag interpolation: " + value[i:])
+		}
+
+		pushText(end + 1)
+
+		// Nodes of the interpolated tag are positioned within the line of the text
+		sub := newParser(strings.NewReader(value[i+2 : end]))
+		sub.filename = p.filename
+		sub.fs = p.fs
+		sub.pathSeparator = p.pathSeparator
+		sub.scanner.lineOffset = text.LineNum - 1
+		sub.scanner.colOffset = col + i + 1
+
+		for _, child := range sub.Parse().Children {
+			if tag, ok := child.(*Tag); ok {
+				tag.IsInterpolated = true
+			}
+			block.push(child)
+		}
+
+		i = end
+	}
+
+	pushText(len(value))
+	return block
 }
 
 func (p *Parser) parseBuffered() *Buffered {
@@ -501,6 +766,20 @@ func (p *Parser) parseAssignment() *Assignment {
 	tok := p.expect(tokAssignment)
 	node := newAssignment(tok.Data["X"], tok.Value)
 	node.SourcePosition = p.pos()
+
+	if tok.Data["Mode"] == "declare" {
+		node.Mode = AssignmentDeclare
+	} else if tok.Data["Mode"] == "reassign" {
+		node.Mode = AssignmentReassign
+	}
+
+	return node
+}
+
+func (p *Parser) parseCode() *Code {
+	tok := p.expect(tokCode)
+	node := newCode(tok.Value)
+	node.SourcePosition = p.pos()
 	return node
 }
 
@@ -559,6 +838,11 @@ readmore:
 			}
 		}
 
+	case tokTextBlock:
+		tag.HasTextBlock = true
+		p.advance()
+		goto readmore
+
 	case tokSemicolon:
 		block := newBlock()
 		block.SourcePosition = p.pos()
@@ -583,7 +867,9 @@ readmore:
 		case tokTag:
 			innerTag := p.parseTag()
 			block.push(innerTag)
-
+		case tokImport:
+			// Allows including stylesheets and scripts into raw text tags, as in `style: include main.css`
+			block.push(p.parseImport())
 		}
 
 	case tokId:
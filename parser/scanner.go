@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strings"
 )
 
 const (
@@ -34,6 +35,9 @@ const (
 	tokBuffered
 	tokSemicolon
 	tokNewLine
+	tokCode
+	tokTextBlock
+	tokFilter
 )
 
 const (
@@ -56,6 +60,10 @@ type scanner struct {
 	lastTokenCol  int
 	lastTokenSize int
 
+	// Position of the input within an enclosing source, like the tags interpolated into a line of text
+	lineOffset int
+	colOffset  int
+
 	readRaw bool
 }
 
@@ -79,7 +87,12 @@ func newScanner(r io.Reader) *scanner {
 }
 
 func (s *scanner) Pos() SourcePosition {
-	return SourcePosition{s.lastTokenLine + 1, s.lastTokenCol + 1, s.lastTokenSize, ""}
+	col := s.lastTokenCol + 1
+	if s.lastTokenLine == 0 {
+		col += s.colOffset
+	}
+
+	return SourcePosition{s.lastTokenLine + 1 + s.lineOffset, col, s.lastTokenSize, ""}
 }
 
 func (s *scanner) Next() *token {
@@ -147,10 +160,22 @@ func (s *scanner) Next() *token {
 			return tok
 		}
 
+		if tok := s.scanCode(); tok != nil {
+			return tok
+		}
+
 		if tok := s.scanBuffered(); tok != nil {
 			return tok
 		}
 
+		if tok := s.scanTranslated(); tok != nil {
+			return tok
+		}
+
+		if tok := s.scanFilter(); tok != nil {
+			return tok
+		}
+
 		if tok := s.scanAssignment(); tok != nil {
 			return tok
 		}
@@ -167,6 +192,10 @@ func (s *scanner) Next() *token {
 			return tok
 		}
 
+		if tok := s.scanTextBlock(); tok != nil {
+			return tok
+		}
+
 		if tok := s.scanClassName(); tok != nil {
 			return tok
 		}
@@ -196,14 +225,26 @@ func (s *scanner) NextRaw() *token {
 
 		switch s.state {
 		case scnEOF:
+			// Indents within the text are not closed by outdents, drop them so that only the
+			// indents of the enclosing blocks are outdented at the end of the input
+			for ; level > 0; level-- {
+				s.indentStack.Remove(s.indentStack.Back())
+			}
+
 			return &token{tokText, result, map[string]string{"Mode": "raw"}, nil}
 		case scnNewLine:
 			s.state = scnLine
 
+			stashed := s.stash.Len()
 			if tok := s.scanIndent(); tok != nil {
 				if tok.Kind == tokIndent {
 					level++
 				} else if tok.Kind == tokOutdent {
+					// A line outdented by several levels stashes all outdents but the returned one
+					for s.stash.Len() > stashed {
+						s.stash.Remove(s.stash.Back())
+						level--
+					}
 					level--
 				} else {
 					result = result + "\n"
@@ -211,7 +252,9 @@ func (s *scanner) NextRaw() *token {
 				}
 
 				if level < 0 {
-					s.stash.PushBack(&token{tokOutdent, "", nil, nil})
+					for ; level < 0; level++ {
+						s.stash.PushBack(&token{tokOutdent, "", nil, nil})
+					}
 
 					if len(result) > 0 && result[len(result)-1] == '\n' {
 						result = result[:len(result)-1]
@@ -231,8 +274,6 @@ func (s *scanner) NextRaw() *token {
 			s.consume(len(s.buffer))
 		}
 	}
-
-	return nil
 }
 
 var rgxIndent = regexp.MustCompile(`^(\s+)`)
@@ -361,6 +402,28 @@ func (s *scanner) scanAssignment() *token {
 	return nil
 }
 
+var rgxCode = regexp.MustCompile(`^-\s+(.+)$`)
+var rgxCodeAssignment = regexp.MustCompile(`^(var\s+)?(\$[\w0-9\-_]*)\s*(:?=)\s*([^=].*)$`)
+
+func (s *scanner) scanCode() *token {
+	if sm := rgxCode.FindStringSubmatch(s.buffer); len(sm) != 0 {
+		s.consume(len(sm[0]))
+
+		if am := rgxCodeAssignment.FindStringSubmatch(sm[1]); len(am) != 0 {
+			mode := "declare"
+			if len(am[1]) == 0 && am[3] == "=" {
+				mode = "reassign"
+			}
+
+			return &token{tokAssignment, am[4], map[string]string{"X": am[2], "Mode": mode}, nil}
+		}
+
+		return &token{tokCode, sm[1], nil, nil}
+	}
+
+	return nil
+}
+
 var rgxComment = regexp.MustCompile(`^\/\/(-)?\s*(.*)$`)
 
 func (s *scanner) scanComment() *token {
@@ -488,12 +551,12 @@ func (s *scanner) scanAttribute() *token {
 	return nil
 }
 
-var rgxImport = regexp.MustCompile(`^import\s+([0-9a-zA-Z_\-\. \/]*)$`)
+var rgxImport = regexp.MustCompile(`^(?:import|include)(?::(\w[-\w]*)(?:\(([^)]*)\))?)?\s+([0-9a-zA-Z_\-\. \/]*|.*#\{.*)$`)
 
 func (s *scanner) scanImport() *token {
 	if sm := rgxImport.FindStringSubmatch(s.buffer); len(sm) != 0 {
 		s.consume(len(sm[0]))
-		return &token{tokImport, sm[1], nil, nil}
+		return &token{tokImport, sm[3], map[string]string{"Filter": sm[1], "Options": sm[2]}, nil}
 	}
 
 	return nil
@@ -580,6 +643,40 @@ func (s *scanner) scanSemicolon() *token {
 	return nil
 }
 
+// A dot ending a tag line, as in `p.` or `script.`, marks the indented block that follows as plain text
+func (s *scanner) scanTextBlock() *token {
+	if strings.TrimRight(s.buffer, " \t") == "." {
+		s.consume(len(s.buffer))
+		return &token{tokTextBlock, "", nil, nil}
+	}
+
+	return nil
+}
+
+var rgxFilter = regexp.MustCompile(`^:(\w[-\w]*)(?:\(([^)]*)\))?(?:\s+(.*))?$`)
+
+// Filters, written as `:markdown` followed by an indented text block, or `:markdown text` for a single line
+func (s *scanner) scanFilter() *token {
+	if sm := rgxFilter.FindStringSubmatch(s.buffer); len(sm) != 0 {
+		s.consume(len(sm[0]))
+		return &token{tokFilter, sm[1], map[string]string{"Options": sm[2], "Text": sm[3]}, nil}
+	}
+
+	return nil
+}
+
+var rgxTranslated = regexp.MustCompile(`^\s*~\s?(.*)$`)
+
+// Translatable text, written as `p~ Welcome back, #{Name}` or `~ Welcome back` on a line of its own
+func (s *scanner) scanTranslated() *token {
+	if sm := rgxTranslated.FindStringSubmatch(s.buffer); len(sm) != 0 {
+		s.consume(len(sm[0]))
+		return &token{tokText, sm[1], map[string]string{"Mode": "translate"}, nil}
+	}
+
+	return nil
+}
+
 func (s *scanner) scanBuffered() *token {
 	i := 0
 	bsize := len(s.buffer)
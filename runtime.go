@@ -2,8 +2,10 @@ package jade
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"math/big"
 	"reflect"
     "strings"
 )
@@ -19,6 +21,23 @@ var FuncMap = template.FuncMap{
 	"__jade_eql":   runtime_eql,
 	"__jade_gtr":   runtime_gtr,
 	"__jade_lss":   runtime_lss,
+	"__jade_loop":  runtime_loop,
+	"__jade_nil":   runtime_nil,
+	"__jade_list":  runtime_list,
+	"__jade_dict":  runtime_dict,
+	"__jade_scope": runtime_scope,
+
+	"__jade_t":      translator{}.translate,
+	"__jade_tn":     translator{}.translatePlural,
+	"__jade_locale": translator{}.locale,
+
+	"__jade_or":         runtime_or,
+	"__jade_and":        runtime_and,
+	"__jade_xor":        runtime_xor,
+	"__jade_andnot":     runtime_andnot,
+	"__jade_shl":        runtime_shl,
+	"__jade_shr":        runtime_shr,
+	"__jade_complement": runtime_complement,
 
 	"json":          runtime_json,
 	"unescaped":     runtime_unescaped,
@@ -27,267 +46,353 @@ var FuncMap = template.FuncMap{
 
 	"upper":        runtime_upper,
 	"lower":        runtime_lower,
+
+	"number": runtime_number,
+	"fixed":  runtime_fixed,
+
+	"trim":       runtime_trim,
+	"replace":    runtime_replace,
+	"split":      runtime_split,
+	"join":       runtime_join,
+	"contains":   runtime_contains,
+	"startsWith": runtime_startsWith,
+	"endsWith":   runtime_endsWith,
+	"truncate":   runtime_truncate,
+	"title":      runtime_title,
+	"slugify":    runtime_slugify,
+	"nl2br":      runtime_nl2br,
+	"pad":        runtime_pad,
+	"repeat":     runtime_repeat,
+
+	"date":     dateFormatter{}.date,
+	"isoDate":  dateFormatter{}.isoDate,
+	"timeAgo":  dateFormatter{}.timeAgo,
+	"relative": dateFormatter{}.timeAgo,
+
+	"first":   runtime_first,
+	"last":    runtime_last,
+	"sortBy":  runtime_sortBy,
+	"groupBy": runtime_groupBy,
+	"filter":  runtime_filter,
+	"chunk":   runtime_chunk,
+	"reverse": runtime_reverse,
+	"uniq":    runtime_uniq,
+	"sum":     runtime_sum,
+	"keys":    runtime_keys,
+	"values":  runtime_values,
 }
 
-func runtime_add(x, y interface{}) interface{} {
-	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Int() + vy.Int()
-			case reflect.Float32, reflect.Float64:
-				return float64(vx.Int()) + vy.Float()
-			case reflect.String:
-				return fmt.Sprintf("%d%s", vx.Int(), vy.String())
-			}
-		}
-	case reflect.Float32, reflect.Float64:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Float() + float64(vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return vx.Float() + vy.Float()
-			case reflect.String:
-				return fmt.Sprintf("%f%s", vx.Float(), vy.String())
-			}
-		}
-	case reflect.String:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return fmt.Sprintf("%s%d", vx.String(), vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return fmt.Sprintf("%s%f", vx.String(), vy.Float())
-			case reflect.String:
-				return fmt.Sprintf("%s%s", vx.String(), vy.String())
-			}
-		}
+// Builds the error reported when an operator can not be applied to its operands.
+func runtime_operatorError(op string, err error, operands ...interface{}) error {
+	types := make([]string, len(operands))
+	for i, operand := range operands {
+		types[i] = fmt.Sprintf("%T", operand)
 	}
 
-	return "<nil>"
+	if len(operands) == 1 {
+		return fmt.Errorf("%s: %s%s", err, op, types[0])
+	}
+
+	return fmt.Errorf("%s: %s %s %s", err, types[0], op, types[1])
 }
 
-func runtime_sub(x, y interface{}) interface{} {
-	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Int() - vy.Int()
-			case reflect.Float32, reflect.Float64:
-				return float64(vx.Int()) - vy.Float()
-			}
-		}
-	case reflect.Float32, reflect.Float64:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Float() - float64(vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return vx.Float() - vy.Float()
-			}
-		}
+var errInvalidOperation = errors.New("invalid operation")
+
+// Evaluates a numeric operation. Operands which are not numbers and failures to compute a result,
+// like overflows and divisions by zero, are reported as errors naming the operator.
+func runtime_numeric(op string, x, y interface{}, fn func(x, y number) (interface{}, error)) (interface{}, error) {
+	nx, okx := toNumber(x)
+	ny, oky := toNumber(y)
+
+	if !okx || !oky {
+		return nil, runtime_operatorError(op, errInvalidOperation, x, y)
+	}
+
+	result, err := fn(nx, ny)
+	if err != nil {
+		return nil, runtime_operatorError(op, err, x, y)
 	}
 
-	return "<nil>"
+	return result, nil
 }
 
-func runtime_mul(x, y interface{}) interface{} {
-	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Int() * vy.Int()
-			case reflect.Float32, reflect.Float64:
-				return float64(vx.Int()) * vy.Float()
-			}
-		}
-	case reflect.Float32, reflect.Float64:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Float() * float64(vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return vx.Float() * vy.Float()
-			}
-		}
+// Evaluates a unary numeric operation.
+func runtime_numericUnary(op string, x interface{}, fn func(x number) (interface{}, error)) (interface{}, error) {
+	nx, ok := toNumber(x)
+	if !ok {
+		return nil, runtime_operatorError(op, errInvalidOperation, x)
 	}
 
-	return "<nil>"
+	result, err := fn(nx)
+	if err != nil {
+		return nil, runtime_operatorError(op, err, x)
+	}
+
+	return result, nil
 }
 
-func runtime_quo(x, y interface{}) interface{} {
-	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Int() / vy.Int()
-			case reflect.Float32, reflect.Float64:
-				return float64(vx.Int()) / vy.Float()
-			}
-		}
-	case reflect.Float32, reflect.Float64:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Float() / float64(vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return vx.Float() / vy.Float()
-			}
-		}
+// Returns the string representation of strings and numbers used for concatenation and comparison.
+func runtime_operandString(x interface{}) (string, bool) {
+	if n, ok := toNumber(x); ok {
+		return n.String(), true
 	}
 
-	return "<nil>"
+	vx := reflect.ValueOf(x)
+	for vx.Kind() == reflect.Ptr && !vx.IsNil() {
+		vx = vx.Elem()
+	}
+
+	if vx.Kind() == reflect.String {
+		return vx.String(), true
+	}
+
+	return "", false
 }
 
-func runtime_rem(x, y interface{}) interface{} {
-	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Int() % vy.Int()
-			}
+func runtime_add(x, y interface{}) (interface{}, error) {
+	if _, ok := toNumber(x); ok {
+		if _, ok := toNumber(y); ok {
+			return runtime_numeric("+", x, y, numericAdd)
 		}
 	}
 
-	return "<nil>"
+	if sx, ok := runtime_operandString(x); ok {
+		if sy, ok := runtime_operandString(y); ok {
+			return sx + sy, nil
+		}
+	}
+
+	return nil, runtime_operatorError("+", errInvalidOperation, x, y)
 }
 
-func runtime_minus(x interface{}) interface{} {
-	vx := reflect.ValueOf(x)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		return -vx.Int()
-	case reflect.Float32, reflect.Float64:
-		return -vx.Float()
-	}
+func runtime_sub(x, y interface{}) (interface{}, error) {
+	return runtime_numeric("-", x, y, numericSub)
+}
 
-	return "<nil>"
+func runtime_mul(x, y interface{}) (interface{}, error) {
+	return runtime_numeric("*", x, y, numericMul)
 }
 
-func runtime_plus(x interface{}) interface{} {
-	vx := reflect.ValueOf(x)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		return +vx.Int()
-	case reflect.Float32, reflect.Float64:
-		return +vx.Float()
+func runtime_quo(x, y interface{}) (interface{}, error) {
+	return runtime_numeric("/", x, y, numericQuo)
+}
+
+func runtime_rem(x, y interface{}) (interface{}, error) {
+	return runtime_numeric("%", x, y, numericRem)
+}
+
+func runtime_minus(x interface{}) (interface{}, error) {
+	return runtime_numericUnary("-", x, numericNegate)
+}
+
+func runtime_plus(x interface{}) (interface{}, error) {
+	return runtime_numericUnary("+", x, func(number) (interface{}, error) {
+		return x, nil
+	})
+}
+
+// Calls the method of x with the given name passing y as the only argument. The method is only called
+// if it accepts y and returns a single value of the given kind.
+func runtime_callMethod(x interface{}, name string, y interface{}, kind reflect.Kind) (reflect.Value, bool) {
+	if x == nil || y == nil {
+		return reflect.Value{}, false
+	}
+
+	method := reflect.ValueOf(x).MethodByName(name)
+	if !method.IsValid() {
+		return reflect.Value{}, false
+	}
+
+	mt := method.Type()
+	if mt.NumIn() != 1 || mt.NumOut() != 1 || mt.Out(0).Kind() != kind || !reflect.TypeOf(y).AssignableTo(mt.In(0)) {
+		return reflect.Value{}, false
 	}
 
-	return "<nil>"
+	return method.Call([]reflect.Value{reflect.ValueOf(y)})[0], true
 }
 
 func runtime_eql(x, y interface{}) bool {
-	if (x == nil && y != nil) || (y == nil && x != nil) {
-		return false
+	// Typed nil pointers, maps and slices compare equal to nil
+	if x == nil || y == nil {
+		return runtime_nil(x) == runtime_nil(y)
 	}
 
-	if x == nil && y == nil {
-		return true
+	// Types like time.Time define their own notion of equality
+	if r, ok := runtime_callMethod(x, "Equal", y, reflect.Bool); ok {
+		return r.Bool()
+	}
+	if r, ok := runtime_callMethod(y, "Equal", x, reflect.Bool); ok {
+		return r.Bool()
 	}
 
-	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Int() == vy.Int()
-			case reflect.Float32, reflect.Float64:
-				return float64(vx.Int()) == vy.Float()
-			case reflect.String:
-				return fmt.Sprintf("%d", vx.Int()) == vy.String()
-			}
+	nx, okx := toNumber(x)
+	ny, oky := toNumber(y)
+
+	if okx && oky {
+		return compareNumbers(nx, ny) == 0
+	}
+
+	if vx := reflect.ValueOf(x); vx.Kind() == reflect.Bool {
+		if vy := reflect.ValueOf(y); vy.Kind() == reflect.Bool {
+			return vx.Bool() == vy.Bool()
 		}
-	case reflect.Float32, reflect.Float64:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Float() == float64(vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return vx.Float() == vy.Float()
-			case reflect.String:
-				return fmt.Sprintf("%f", vx.Float()) == vy.String()
-			}
+
+		if oky && ny.isInteger() {
+			return vx.Bool() && ny.bigInt().Sign() != 0
 		}
-	case reflect.String:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.String() == fmt.Sprintf("%d", vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return vx.String() == fmt.Sprintf("%f", vy.Float())
-			case reflect.String:
-				return vx.String() == fmt.Sprintf("%s", vy.String())
-			}
+
+		return false
+	}
+
+	if sx, ok := runtime_operandString(x); ok {
+		if sy, ok := runtime_operandString(y); ok {
+			return sx == sy
 		}
-	case reflect.Bool:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Bool() && vy.Int() != 0
-			case reflect.Bool:
-				return vx.Bool() == vy.Bool()
-			}
+	}
+
+	return reflect.DeepEqual(x, y)
+}
+
+// Returns -1, 0 or +1 depending on whether x is ordered before, equal to or after y. Values providing
+// a Compare or Before method (like time.Time) are ordered by it, numbers by value and strings lexically.
+func runtime_compare(op string, x, y interface{}) (int, error) {
+	if r, ok := runtime_callMethod(x, "Compare", y, reflect.Int); ok {
+		return int(r.Int()), nil
+	}
+
+	if r, ok := runtime_callMethod(x, "Before", y, reflect.Bool); ok {
+		if r.Bool() {
+			return -1, nil
+		}
+
+		if r, _ := runtime_callMethod(y, "Before", x, reflect.Bool); r.IsValid() && r.Bool() {
+			return 1, nil
 		}
+
+		return 0, nil
 	}
 
-	return false
+	nx, okx := toNumber(x)
+	ny, oky := toNumber(y)
+
+	if okx && oky {
+		return compareNumbers(nx, ny), nil
+	}
+
+	if sx, ok := runtime_operandString(x); ok {
+		if sy, ok := runtime_operandString(y); ok {
+			return strings.Compare(sx, sy), nil
+		}
+	}
+
+	return 0, runtime_operatorError(op, errInvalidOperation, x, y)
 }
 
-func runtime_lss(x, y interface{}) bool {
-	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
-	switch vx.Kind() {
-	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Int() < vy.Int()
-			case reflect.Float32, reflect.Float64:
-				return float64(vx.Int()) < vy.Float()
-			case reflect.String:
-				return fmt.Sprintf("%d", vx.Int()) < vy.String()
-			}
+func runtime_lss(x, y interface{}) (bool, error) {
+	r, err := runtime_compare("<", x, y)
+	return r < 0, err
+}
+
+func runtime_gtr(x, y interface{}) (bool, error) {
+	r, err := runtime_compare(">", x, y)
+	return r > 0, err
+}
+
+func runtime_bitwise(op string, x, y interface{}, fn func(r, a, b *big.Int) *big.Int) (interface{}, error) {
+	return runtime_numeric(op, x, y, func(nx, ny number) (interface{}, error) {
+		return numericBitwise(nx, ny, fn)
+	})
+}
+
+func runtime_or(x, y interface{}) (interface{}, error) {
+	return runtime_bitwise("|", x, y, (*big.Int).Or)
+}
+
+func runtime_and(x, y interface{}) (interface{}, error) {
+	return runtime_bitwise("&", x, y, (*big.Int).And)
+}
+
+func runtime_xor(x, y interface{}) (interface{}, error) {
+	return runtime_bitwise("^", x, y, (*big.Int).Xor)
+}
+
+func runtime_andnot(x, y interface{}) (interface{}, error) {
+	return runtime_bitwise("&^", x, y, (*big.Int).AndNot)
+}
+
+func runtime_shl(x, y interface{}) (interface{}, error) {
+	return runtime_numeric("<<", x, y, func(nx, ny number) (interface{}, error) {
+		return numericShift(nx, ny, true)
+	})
+}
+
+func runtime_shr(x, y interface{}) (interface{}, error) {
+	return runtime_numeric(">>", x, y, func(nx, ny number) (interface{}, error) {
+		return numericShift(nx, ny, false)
+	})
+}
+
+func runtime_complement(x interface{}) (interface{}, error) {
+	return runtime_numericUnary("^", x, numericComplement)
+}
+
+// Wraps the runtime operators of FuncMap so that failing operations yield an empty string (or false
+// for comparisons) instead of aborting the template execution.
+func lenientFuncMap() template.FuncMap {
+	funcs := template.FuncMap{}
+
+	for name, fn := range FuncMap {
+		fv := reflect.ValueOf(fn)
+		ft := fv.Type()
+
+		if !strings.HasPrefix(name, "__jade_") || ft.NumOut() != 2 {
+			continue
 		}
-	case reflect.Float32, reflect.Float64:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.Float() < float64(vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return vx.Float() < vy.Float()
-			case reflect.String:
-				return fmt.Sprintf("%f", vx.Float()) < vy.String()
+
+		funcs[name] = reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+			results := fv.Call(args)
+			if results[1].IsNil() {
+				return results
 			}
-		}
-	case reflect.String:
-		{
-			switch vy.Kind() {
-			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Int16, reflect.Int8:
-				return vx.String() < fmt.Sprintf("%d", vy.Int())
-			case reflect.Float32, reflect.Float64:
-				return vx.String() < fmt.Sprintf("%f", vy.Float())
-			case reflect.String:
-				return vx.String() < vy.String()
+
+			value := reflect.Zero(ft.Out(0))
+			if ft.Out(0).Kind() == reflect.Interface {
+				value = reflect.ValueOf("").Convert(ft.Out(0))
 			}
-		}
+
+			return []reflect.Value{value, reflect.Zero(ft.Out(1))}
+		}).Interface()
+	}
+
+	return funcs
+}
+
+func runtime_nil(x interface{}) bool {
+	if x == nil {
+		return true
+	}
+
+	vx := reflect.ValueOf(x)
+	switch vx.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+		return vx.IsNil()
 	}
 
 	return false
 }
 
-func runtime_gtr(x, y interface{}) bool {
-	return !runtime_lss(x, y) && !runtime_eql(x, y)
+func runtime_list(items ...interface{}) []interface{} {
+	return items
+}
+
+func runtime_dict(pairs ...interface{}) map[string]interface{} {
+	dict := make(map[string]interface{}, len(pairs)/2)
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		dict[fmt.Sprint(pairs[i])] = pairs[i+1]
+	}
+
+	return dict
 }
 
 func runtime_json(x interface{}) (res string, err error) {
@@ -316,3 +421,93 @@ func runtime_lower(arg string) string {
     return strings.ToLower(arg)
 }
 
+// Converts a template value into a number, numeric strings are accepted as well.
+func runtime_toNumber(name string, x interface{}) (number, error) {
+	n, ok := toNumber(x)
+	if str, isString := x.(string); isString {
+		n, ok = toNumber(json.Number(strings.TrimSpace(str)))
+	}
+
+	if !ok {
+		return number{}, fmt.Errorf("%s: %T is not a number", name, x)
+	}
+
+	return n, nil
+}
+
+// Formats a number with grouped thousands. The number is the last argument, it can be preceded by the
+// number of decimals and a locale selecting the separators, like in `number 2 "de" Price`.
+func runtime_number(args ...interface{}) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("number: missing value")
+	}
+
+	n, err := runtime_toNumber("number", args[len(args)-1])
+	if err != nil {
+		return "", err
+	}
+
+	precision, locale := -1, ""
+	for _, arg := range args[:len(args)-1] {
+		if str, ok := arg.(string); ok {
+			locale = str
+		} else if p, ok := toNumber(arg); ok && p.isInteger() {
+			precision = int(p.bigInt().Int64())
+		} else {
+			return "", fmt.Errorf("number: invalid argument of type %T", arg)
+		}
+	}
+
+	group, decimal := localeSeparators(locale)
+	return formatNumber(n, precision, group, decimal), nil
+}
+
+// Formats a number with a fixed number of decimals, like in `fixed 2 Price`.
+func runtime_fixed(precision int, x interface{}) (string, error) {
+	n, err := runtime_toNumber("fixed", x)
+	if err != nil {
+		return "", err
+	}
+
+	return formatNumber(n, precision, "", "."), nil
+}
+
+// Loop describes the state of the innermost each block. It is available as $loop inside the block.
+type Loop struct {
+	// Zero based index of the current item
+	Index int
+	// Number of items in the iterated collection, -1 if it can not be determined (channels)
+	Length int
+	// State of the enclosing each block, nil for top level loops
+	Parent *Loop
+}
+
+// Reports whether the current item is the first one.
+func (l *Loop) First() bool {
+	return l.Index == 0
+}
+
+// Reports whether the current item is the last one.
+func (l *Loop) Last() bool {
+	return l.Index == l.Length-1
+}
+
+// Advances the loop to the next item. Compiled templates call this at the start of each iteration.
+func (l *Loop) Next() *Loop {
+	l.Index++
+	return l
+}
+
+func runtime_loop(collection interface{}, parent *Loop) *Loop {
+	loop := &Loop{Index: -1, Length: -1, Parent: parent}
+
+	vc := reflect.Indirect(reflect.ValueOf(collection))
+	switch vc.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String:
+		loop.Length = vc.Len()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		loop.Length = int(vc.Int())
+	}
+
+	return loop
+}
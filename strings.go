@@ -0,0 +1,224 @@
+package jade
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// String helpers take the string they work on as their last argument, so that they can be used as
+// pipe stages: `Title | truncate 20`. Any value is accepted and converted into a string first.
+
+// Converts a template value into a string, numbers are formatted like in expressions.
+func runtime_string(x interface{}) string {
+	if x == nil {
+		return ""
+	}
+
+	if str, ok := runtime_operandString(x); ok {
+		return str
+	}
+
+	if stringer, ok := x.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+
+	return fmt.Sprint(x)
+}
+
+// Removes leading and trailing white space, or the given characters: `trim "/" Path`.
+func runtime_trim(args ...interface{}) (string, error) {
+	switch len(args) {
+	case 1:
+		return strings.TrimSpace(runtime_string(args[0])), nil
+	case 2:
+		return strings.Trim(runtime_string(args[1]), runtime_string(args[0])), nil
+	}
+
+	return "", errors.New("trim: expected a string and optional characters to remove")
+}
+
+func runtime_replace(old, new, x interface{}) string {
+	return strings.Replace(runtime_string(x), runtime_string(old), runtime_string(new), -1)
+}
+
+func runtime_split(sep, x interface{}) []string {
+	return strings.Split(runtime_string(x), runtime_string(sep))
+}
+
+// Joins the elements of a slice or array: `join ", " Tags`.
+func runtime_join(sep, list interface{}) (string, error) {
+	vl := reflect.ValueOf(list)
+	if vl.Kind() != reflect.Slice && vl.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: %T is not a list", list)
+	}
+
+	items := make([]string, vl.Len())
+	for i := range items {
+		items[i] = runtime_string(vl.Index(i).Interface())
+	}
+
+	return strings.Join(items, runtime_string(sep)), nil
+}
+
+// Reports whether a string contains a substring, a list contains an item or a map contains a key.
+func runtime_contains(needle, haystack interface{}) bool {
+	vh := reflect.ValueOf(haystack)
+
+	switch vh.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < vh.Len(); i++ {
+			if runtime_eql(vh.Index(i).Interface(), needle) {
+				return true
+			}
+		}
+		return false
+	case reflect.Map:
+		for _, key := range vh.MapKeys() {
+			if runtime_eql(key.Interface(), needle) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return strings.Contains(runtime_string(haystack), runtime_string(needle))
+}
+
+func runtime_startsWith(prefix, x interface{}) bool {
+	return strings.HasPrefix(runtime_string(x), runtime_string(prefix))
+}
+
+func runtime_endsWith(suffix, x interface{}) bool {
+	return strings.HasSuffix(runtime_string(x), runtime_string(suffix))
+}
+
+// Shortens a string to at most length characters including the ellipsis, which defaults to "…":
+// `truncate 20 Title` or `truncate 20 "..." Title`.
+func runtime_truncate(length int, args ...interface{}) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", errors.New("truncate: expected a string and an optional ellipsis")
+	}
+
+	str, ellipsis := runtime_string(args[len(args)-1]), "…"
+	if len(args) == 2 {
+		ellipsis = runtime_string(args[0])
+	}
+
+	if utf8.RuneCountInString(str) <= length {
+		return str, nil
+	}
+
+	keep := length - utf8.RuneCountInString(ellipsis)
+	if keep < 0 {
+		keep = 0
+	}
+
+	runes := []rune(str)
+	return strings.TrimRightFunc(string(runes[:keep]), unicode.IsSpace) + ellipsis, nil
+}
+
+// Upper cases the first letter of every word.
+func runtime_title(x interface{}) string {
+	runes := []rune(runtime_string(x))
+
+	for i := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) || runes[i-1] == '-' {
+			runes[i] = unicode.ToTitle(runes[i])
+		}
+	}
+
+	return string(runes)
+}
+
+// Replacements of letters that have no ASCII decomposition.
+var slugReplacements = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ł': "l", 'þ': "th",
+}
+
+// Letters with diacritics mapped to their base letter.
+var slugLetters = map[string]string{
+	"àáâãäåāą": "a", "çćč": "c", "ďð": "d", "èéêëēęě": "e", "ìíîïī": "i", "ñńň": "n",
+	"òóôõöō": "o", "řŕ": "r", "śšş": "s", "ťţ": "t", "ùúûüūů": "u", "ýÿ": "y", "źżž": "z",
+}
+
+func init() {
+	for letters, base := range slugLetters {
+		for _, letter := range letters {
+			slugReplacements[letter] = base
+		}
+	}
+}
+
+// Converts a string into a lower case, URL friendly identifier: "Hello, Wörld!" becomes "hello-world".
+func runtime_slugify(x interface{}) string {
+	var buf strings.Builder
+	dash := false
+
+	for _, ch := range strings.ToLower(runtime_string(x)) {
+		if replacement, ok := slugReplacements[ch]; ok {
+			buf.WriteString(replacement)
+			dash = false
+		} else if ch < utf8.RuneSelf && (unicode.IsLetter(ch) || unicode.IsDigit(ch)) {
+			buf.WriteRune(ch)
+			dash = false
+		} else if !dash && buf.Len() > 0 {
+			buf.WriteByte('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(buf.String(), "-")
+}
+
+// Escapes a string and converts its line breaks into <br> tags.
+func runtime_nl2br(x interface{}) template.HTML {
+	str := template.HTMLEscapeString(runtime_string(x))
+	str = strings.Replace(str, "\r\n", "\n", -1)
+	return template.HTML(strings.Replace(str, "\n", "<br>", -1))
+}
+
+// Pads a string with spaces, or the given character, to width characters. Positive widths align the
+// string to the right, negative widths to the left: `pad 5 "0" Number`.
+func runtime_pad(width int, args ...interface{}) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", errors.New("pad: expected a string and an optional padding character")
+	}
+
+	str, padding := runtime_string(args[len(args)-1]), " "
+	if len(args) == 2 {
+		padding = runtime_string(args[0])
+	}
+
+	if utf8.RuneCountInString(padding) != 1 {
+		return "", fmt.Errorf("pad: padding %q must be a single character", padding)
+	}
+
+	left := width > 0
+	if !left {
+		width = -width
+	}
+
+	count := width - utf8.RuneCountInString(str)
+	if count <= 0 {
+		return str, nil
+	}
+
+	if left {
+		return strings.Repeat(padding, count) + str, nil
+	}
+
+	return str + strings.Repeat(padding, count), nil
+}
+
+func runtime_repeat(count int, x interface{}) (string, error) {
+	if count < 0 {
+		return "", fmt.Errorf("repeat: negative count %d", count)
+	}
+
+	return strings.Repeat(runtime_string(x), count), nil
+}
@@ -0,0 +1 @@
+var ready = 1 < 2;